@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/urfave/cli/v2"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	CategoryAMI = "ami"
+
+	AMI_DESC = `
+	manage AMIs.
+
+	create AMI from the selected instances. --name is template of AMI name.
+	{{.Name}} is Name tag of the instance, {{.InstanceId}} is instance id and {{.Date}} is creation time (YYYYMMDD-hhmmss).
+
+	    rnzoo ami create --name '{{.Name}}-{{.Date}}' --no-reboot
+
+	list AMIs that owned by you (--owner self). the list is cached, --force option reloads from AWS.
+
+	    rnzoo ami ls --name 'web-*'
+
+	deregister the selected AMIs and delete the snapshots that the AMIs used.
+
+	    rnzoo ami rm
+	`
+
+	DEFAULT_AMI_NAME_TEMPLATE = "{{.Name}}-{{.Date}}"
+
+	TAG_SOURCE_INSTANCE = "rnzoo:source-instance"
+)
+
+var commandAMI = cli.Command{
+	Name:        "ami",
+	Category:    CategoryAMI,
+	Usage:       "create, list and deregister AMIs.",
+	Description: AMI_DESC,
+	Subcommands: []*cli.Command{
+		{
+			Name:   "create",
+			Usage:  "create AMI from the selected instances.",
+			Action: doAMICreate,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:  OPT_INSTANCE_ID,
+					Usage: "specify instance id.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_NAME,
+					Value: DEFAULT_AMI_NAME_TEMPLATE,
+					Usage: "AMI name template. {{.Name}}, {{.InstanceId}} and {{.Date}} are available.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_DESCRIPTION,
+					Usage: "AMI description.",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_NO_REBOOT,
+					Usage: "create AMI without rebooting the instance. the file system integrity is not guaranteed.",
+				},
+			},
+		},
+		{
+			Name:    "ls",
+			Aliases: []string{"list"},
+			Usage:   "list AMIs.",
+			Action:  doAMIList,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.BoolFlag{
+					Name:    OPT_FORCE,
+					Aliases: []string{"f"},
+					Usage:   "reload AMIs (force connect to AWS)",
+				},
+				&cli.StringFlag{
+					Name:  OPT_OWNER,
+					Value: myec2.AMI_OWNER_SELF,
+					Usage: "AMI owner. self, amazon or AWS account id.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_NAME,
+					Usage: "filter AMI name with wildcard(*, ?).",
+				},
+				&cli.BoolFlag{
+					Name:    OPT_TSV,
+					Aliases: []string{"t"},
+					Usage:   EC2LIST_TSV,
+				},
+			},
+		},
+		{
+			Name:   "rm",
+			Usage:  "deregister the selected AMIs and delete their snapshots.",
+			Action: doAMIRemove,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.BoolFlag{
+					Name:    OPT_FORCE,
+					Aliases: []string{"f"},
+					Usage:   "reload AMIs (force connect to AWS)",
+				},
+				&cli.StringFlag{
+					Name:  OPT_NAME,
+					Usage: "filter AMI name with wildcard(*, ?).",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_KEEP_SNAPSHOTS,
+					Usage: "does not delete the snapshots that the AMI used.",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_WITHOUT_CONFIRM,
+					Usage: "without confirm target before action (default action is do confirming)",
+				},
+			},
+		},
+	},
+}
+
+type AMINameReplacement struct {
+	Name       string
+	InstanceId string
+	Date       string
+}
+
+func (r *AMINameReplacement) StringWithTemplate(templateString string) (string, error) {
+	t := template.New("ami name template").Option("missingkey=error")
+	t, err := t.Parse(templateString)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	err = t.Execute(buf, r)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func doAMICreate(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	var ids []string
+	if instanceId := c.String(OPT_INSTANCE_ID); instanceId != "" {
+		if err := validateInstanceId(instanceId); err != nil {
+			return ErrExit("invalid instance id format: %s", err.Error())
+		}
+		ids = []string{instanceId}
+	} else {
+		h, err := NewRnzooCStoreManager()
+		if err != nil {
+			log.Printf("can not load EC2: %v", err)
+		}
+
+		ids, err = h.ChooseEC2(region, myec2.EC2_STATE_ANY, true)
+		if err != nil {
+			return ErrExit("error during selecting: %s", err.Error())
+		}
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	insts, err := myec2.GetInstancesFromId(ctx, cli, ids...)
+	if err != nil {
+		return ErrExit("failed retrieve instance info: %v", err)
+	}
+
+	date := time.Now().Format("20060102-150405")
+	for _, ins := range insts {
+		instanceId := convertNilString(ins.InstanceId)
+		name := instanceId
+		for _, t := range ins.Tags {
+			if convertNilString(t.Key) == "Name" {
+				name = convertNilString(t.Value)
+				break
+			}
+		}
+
+		r := &AMINameReplacement{
+			Name:       name,
+			InstanceId: instanceId,
+			Date:       date,
+		}
+
+		amiName, err := r.StringWithTemplate(c.String(OPT_NAME))
+		if err != nil {
+			return ErrExit("failed replacing AMI name template: %v", err)
+		}
+
+		tags := []types.Tag{
+			{Key: aws.String("Name"), Value: aws.String(amiName)},
+			{Key: aws.String(TAG_SOURCE_INSTANCE), Value: aws.String(instanceId)},
+		}
+
+		imageId, err := myec2.CreateImage(ctx, cli, instanceId, amiName, c.String(OPT_DESCRIPTION), c.Bool(OPT_NO_REBOOT), tags)
+		if err != nil {
+			return ErrExit("failed create AMI from %s: %v", instanceId, err)
+		}
+
+		log.Printf("creating image_id:%s\tname:%s\tinstance_id:%s", imageId, amiName, instanceId)
+	}
+
+	// refresh cache for adding the created AMIs.
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		debug(fmt.Sprintf("can not load AMI: %v", err))
+		return nil
+	}
+	if _, err := h.LoadChoosableAMIList(ctx, region, myec2.AMI_OWNER_SELF, "", true); err != nil {
+		debug(fmt.Sprintf("failed refresh AMI cache: %v", err))
+	}
+
+	return nil
+}
+
+func doAMIList(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	err = CreateRnzooDir()
+	if err != nil {
+		return ErrExit("can not create rnzoo dir: %s", err.Error())
+	}
+
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		log.Printf("can not load AMI: %s", err.Error())
+	}
+
+	amis, err := h.LoadChoosableAMIList(c.Context, region, c.String(OPT_OWNER), c.String(OPT_NAME), c.Bool(OPT_FORCE))
+	if err != nil {
+		return ErrExit("can not load AMI: %s", err.Error())
+	}
+
+	for _, a := range amis {
+		if c.Bool(OPT_TSV) {
+			fmt.Println(a)
+		} else {
+			fmt.Println(a.Choice())
+		}
+	}
+
+	return nil
+}
+
+func doAMIRemove(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		log.Printf("can not load AMI: %v", err)
+	}
+
+	// only own AMIs can be deregistered.
+	ctx := c.Context
+	amis, err := h.ChooseAMI(ctx, region, myec2.AMI_OWNER_SELF, c.String(OPT_NAME), c.Bool(OPT_FORCE))
+	if err != nil {
+		return ErrExit("error during selecting: %s", err.Error())
+	}
+
+	if len(amis) == 0 {
+		return ErrExit("no AMI selected.")
+	}
+
+	keepSnapshots := c.Bool(OPT_KEEP_SNAPSHOTS)
+	if !c.Bool(OPT_WITHOUT_CONFIRM) {
+		for _, a := range amis {
+			snapshots := "(keep snapshots)"
+			if !keepSnapshots {
+				snapshots = strings.Join(a.SnapshotIds, ",")
+			}
+			fmt.Printf("%s\t%s\t%s\n", a.ImageId, a.Name, snapshots)
+		}
+
+		ans, err := confirm("deregister above AMIs and delete the snapshots?", false)
+		if err != nil {
+			return ErrExit("failed confirm: %v", err)
+		}
+		if !ans {
+			return ErrExit("canceled AMI remove action.")
+		}
+	}
+
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	for _, a := range amis {
+		snapshotIds := a.SnapshotIds
+		if keepSnapshots {
+			snapshotIds = nil
+		}
+
+		if err := myec2.DeregisterImage(ctx, cli, a.ImageId, snapshotIds); err != nil {
+			return ErrExit("failed remove AMI: %v", err)
+		}
+
+		log.Printf("deregistered image_id:%s\tname:%s\tdeleted snapshots:%s", a.ImageId, a.Name, strings.Join(snapshotIds, ","))
+	}
+
+	// refresh cache for removing the deregistered AMIs.
+	if _, err := h.LoadChoosableAMIList(ctx, region, myec2.AMI_OWNER_SELF, "", true); err != nil {
+		debug(fmt.Sprintf("failed refresh AMI cache: %v", err))
+	}
+
+	return nil
+}
@@ -44,12 +44,76 @@ const (
 	OPT_CONFIRM      = "confirm"
 	OPT_SPECIFY_NAME = "specify-name"
 
+	OPT_FALLBACK_ON_DEMAND = "fallback-on-demand"
+	OPT_EXPORT_TEMPLATE    = "export-template"
+	OPT_VALIDATE           = "validate"
+	OPT_CHECK_AWS          = "check-aws"
+	OPT_VAR                = "var"
+	OPT_ENV                = "env"
+	OPT_PLAN               = "plan"
+	OPT_JSON               = "json"
+	OPT_ENSURE             = "ensure"
+	OPT_ATOMIC             = "atomic"
+	OPT_YES                = "yes"
+
+	OPT_WITHOUT_CLIENT_TOKEN = "without-client-token"
+
 	OPT_EC2_ANY_STATE   = "ec2-any-state"
 	OPT_EXECUTE         = "execute"
 	OPT_WITHOUT_CONFIRM = "without-confirm"
 
 	OPT_TAG_PAIRS       = "pairs"
 	OPT_TAG_DELETE_KEYS = "delete-keys"
+
+	OPT_NAME           = "name"
+	OPT_OWNER          = "owner"
+	OPT_DESCRIPTION    = "description"
+	OPT_NO_REBOOT      = "no-reboot"
+	OPT_KEEP_SNAPSHOTS = "keep-snapshots"
+
+	OPT_VOLUME_ID    = "volume-id"
+	OPT_DEVICE       = "device"
+	OPT_SIZE         = "size"
+	OPT_FORCE_DETACH = "force-detach"
+	OPT_AVAILABLE    = "available"
+	OPT_TO_REGION    = "to-region"
+
+	OPT_SG_ID    = "sg-id"
+	OPT_PORT     = "port"
+	OPT_PROTOCOL = "protocol"
+	OPT_CIDR     = "cidr"
+	OPT_TTL      = "ttl"
+	OPT_ALL      = "all"
+
+	OPT_USER     = "user"
+	OPT_ADDRESS  = "address"
+	OPT_JUMP     = "jump"
+	OPT_IDENTITY = "identity"
+	OPT_WRITE    = "write"
+	OPT_PREFIX   = "prefix"
+
+	OPT_LOCAL       = "local"
+	OPT_REMOTE      = "remote"
+	OPT_REMOTE_HOST = "remote-host"
+
+	OPT_TAG             = "tag"
+	OPT_MAX_CONCURRENCY = "max-concurrency"
+	OPT_MAX_ERRORS      = "max-errors"
+	OPT_TIMEOUT         = "timeout"
+
+	OPT_PARALLEL  = "parallel"
+	OPT_RSYNC     = "rsync"
+	OPT_RECURSIVE = "recursive"
+
+	OPT_POOL    = "pool"
+	OPT_PURPOSE = "purpose"
+	OPT_COUNT   = "count"
+
+	OPT_EIP        = "eip"
+	OPT_TO         = "to"
+	OPT_TO_ENI     = "to-eni"
+	OPT_PRIVATE_IP = "private-ip"
+	OPT_SWAP       = "swap"
 )
 
 var silent bool
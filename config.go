@@ -31,7 +31,32 @@ func doInit(c *cli.Context) error {
 }
 
 func GetDefaultConfig() (*RnzooConfig, error) {
-	// load config
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &config.Default, nil
+}
+
+// returns empty ssh settings if there is no config file or no ssh section.
+func GetSSHConfig() (*SSHConfig, error) {
+	config, err := loadConfig()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &SSHConfig{}, nil
+		}
+		return nil, err
+	}
+
+	if config.SSH == nil {
+		return &SSHConfig{}, nil
+	}
+
+	return config.SSH, nil
+}
+
+func loadConfig() (*Config, error) {
 	m, err := NewCStoreManager()
 	if err != nil {
 		return nil, err
@@ -48,11 +73,41 @@ func GetDefaultConfig() (*RnzooConfig, error) {
 		return nil, err
 	}
 
-	return &config.Default, nil
+	return &config, nil
 }
 
 type Config struct {
 	Default RnzooConfig
+	SSH     *SSHConfig `toml:"ssh,omitempty"`
+}
+
+// ssh settings in config file. (~/.rnzoo/config)
+//
+//	[ssh]
+//	address_preference = ["public", "private", "ipv6"]
+//	default_user = "ec2-user"
+//	bastion = "ec2-user@bastion.example.com"
+//	[ssh.key_files]
+//	my-key = "~/.ssh/my-key.pem"
+//	[[ssh.rules]]
+//	tag = "Role=web"
+//	user = "ubuntu"
+//	identity_file = "~/.ssh/web.pem"
+type SSHConfig struct {
+	AddressPreference []string          `toml:"address_preference,omitempty"`
+	DefaultUser       string            `toml:"default_user,omitempty"`
+	UserTag           string            `toml:"user_tag,omitempty"`
+	KeyFiles          map[string]string `toml:"key_files,omitempty"`
+	Bastion           string            `toml:"bastion,omitempty"`
+	Rules             []SSHRule         `toml:"rules,omitempty"`
+}
+
+// user and identity file for the instances that have the tag.
+// tag is Key=Value or Key (the instances that have the key).
+type SSHRule struct {
+	Tag          string `toml:"tag"`
+	User         string `toml:"user,omitempty"`
+	IdentityFile string `toml:"identity_file,omitempty"`
 }
 
 func (c *Config) Validate() error {
@@ -84,11 +139,12 @@ func DoConfigWizard(cs *cstore.CStore) error {
 		break
 	}
 
-	c := &Config{
-		Default: RnzooConfig{
-			AWSRegion: region,
-		},
+	// keep other settings in existing config.
+	c := &Config{}
+	if err := cs.GetWithoutValidate(c); err != nil {
+		c = &Config{}
 	}
+	c.Default.AWSRegion = region
 
 	if err := cs.Save(c); err != nil {
 		return err
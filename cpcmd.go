@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	CP_DESC = `
+	copy files to/from the selected running instances with scp (or rsync) in parallel.
+	remote path starts with ':'. address, user and key are decided as same as ssh subcommand.
+
+	upload to all selected instances:
+
+	    rnzoo cp --tag Role=web local.tar :/tmp/
+
+	download from the instances into the per instance directories (named by Name tag):
+
+	    rnzoo cp --tag Role=web :/var/log/app.log ./logs/
+	    => ./logs/web-1/app.log, ./logs/web-2/app.log
+
+	ssh runs with BatchMode, so the host key and the key must be ready without prompt.
+	`
+
+	REMOTE_PATH_PREFIX = ":"
+)
+
+var commandCp = cli.Command{
+	Name:        "cp",
+	Category:    CategorySSH,
+	Usage:       "copy files to/from the instances with scp or rsync in parallel.",
+	Description: CP_DESC,
+	ArgsUsage:   "SRC... DEST (remote path starts with ':')",
+	Action:      doCp,
+	Flags: append(sshFlags(),
+		&cli.StringSliceFlag{
+			Name:  OPT_TAG,
+			Usage: "select running instances that have the tag. Key=Value",
+		},
+		&cli.IntFlag{
+			Name:  OPT_PARALLEL,
+			Value: 5,
+			Usage: "max number of the parallel copies.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_RSYNC,
+			Usage: "use rsync instead of scp.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_RECURSIVE,
+			Usage: "copy directories recursively.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_DRYRUN,
+			Usage: "show copy commands without executing.",
+		},
+	),
+}
+
+func isRemotePath(path string) bool {
+	return strings.HasPrefix(path, REMOTE_PATH_PREFIX)
+}
+
+// user@host:path, IPv6 address is bracketed.
+func remoteSpec(t *SSHTarget, path string) string {
+	host := t.Address
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	if t.User != "" {
+		host = t.User + "@" + host
+	}
+
+	return host + ":" + strings.TrimPrefix(path, REMOTE_PATH_PREFIX)
+}
+
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// quote with single quotes if it has special characters.
+func shellQuote(s string) string {
+	if shellSafePattern.MatchString(s) {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+type CopyJob struct {
+	Target *SSHTarget
+	Alias  string
+	Args   []string
+	// local directory that is created before download.
+	LocalDir string
+}
+
+func makeCopyArgs(t *SSHTarget, useRsync, recursive bool, srcs []string, dest string, download bool) []string {
+	sshOpts := append([]string{"-o", "BatchMode=yes"}, t.Options()...)
+
+	var args []string
+	if useRsync {
+		// rsync splits -e string like shell, so quote each option for spaces in key path etc.
+		quoted := make([]string, 0, len(sshOpts)+1)
+		quoted = append(quoted, "ssh")
+		for _, o := range sshOpts {
+			quoted = append(quoted, shellQuote(o))
+		}
+		args = []string{"rsync", "-a", "-e", strings.Join(quoted, " ")}
+	} else {
+		args = append([]string{"scp", "-p"}, sshOpts...)
+		if recursive {
+			args = append(args, "-r")
+		}
+	}
+
+	if download {
+		for _, s := range srcs {
+			args = append(args, remoteSpec(t, s))
+		}
+		return append(args, dest)
+	}
+
+	args = append(args, srcs...)
+	return append(args, remoteSpec(t, dest))
+}
+
+func doCp(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	args := c.Args().Slice()
+	if len(args) < 2 {
+		return ErrExit("required SRC and DEST. e.g. rnzoo cp local.tar :/tmp/")
+	}
+	srcs, dest := args[:len(args)-1], args[len(args)-1]
+
+	download := isRemotePath(srcs[0])
+	for _, s := range srcs {
+		if isRemotePath(s) != download {
+			return ErrExit("SRC must be all local or all remote paths.")
+		}
+	}
+	if isRemotePath(dest) == download {
+		return ErrExit("either SRC or DEST must be remote path that starts with '%s'.", REMOTE_PATH_PREFIX)
+	}
+
+	parallel := c.Int(OPT_PARALLEL)
+	if parallel < 1 {
+		return ErrExit("parallel must be 1 or more: %d", parallel)
+	}
+
+	opts, err := NewSSHOptions(c)
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	conf, err := GetSSHConfig()
+	if err != nil {
+		return ErrExit("can not load rnzoo config: %v", err)
+	}
+
+	selected, err := selectRunningInstances(c, region, nil)
+	if err != nil {
+		return ErrExit("error during selecting: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	// the cache may be old, so get current addresses.
+	ids := make([]string, 0, len(selected))
+	for _, ins := range selected {
+		ids = append(ids, convertNilString(ins.InstanceId))
+	}
+
+	insts, err := myec2.GetInstancesFromId(ctx, cli, ids...)
+	if err != nil {
+		return ErrExit("failed retrieve instance info: %v", err)
+	}
+
+	preference, err := addressPreference(conf, opts)
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	images, err := getInstanceImages(ctx, cli, insts)
+	if err != nil {
+		// user is decided by default if AMI is not found.
+		debug(fmt.Sprintf("failed get AMIs: %v", err))
+	}
+
+	// the instance that has no usable address is failed, and the others are copied.
+	failed := make([]string, 0)
+	targets := make([]*SSHTarget, 0, len(insts))
+	for _, ins := range insts {
+		t, err := newSSHTarget(conf, opts, preference, ins, images[convertNilString(ins.ImageId)])
+		if err != nil {
+			log.Printf("skip: %v", err)
+			id := convertNilString(ins.InstanceId)
+			if name := getInstanceTag(ins, "Name"); name != "" {
+				id = name
+			}
+			failed = append(failed, id)
+			continue
+		}
+
+		targets = append(targets, t)
+	}
+
+	if len(targets) == 0 {
+		return ErrExit("there is no instance that can copy: %s", strings.Join(failed, ","))
+	}
+
+	// per instance directory names are unique like ssh-config Host alias.
+	used := make(map[string]bool, len(targets))
+	jobs := make([]*CopyJob, 0, len(targets))
+	for _, t := range targets {
+		base := sanitizeHostAlias(t.Name)
+		if base == "" {
+			base = t.InstanceId
+		}
+
+		alias := base
+		for i := 2; used[alias]; i++ {
+			alias = base + "-" + strconv.Itoa(i)
+		}
+		used[alias] = true
+
+		job := &CopyJob{Target: t, Alias: alias}
+		if download {
+			job.LocalDir = filepath.Join(dest, alias)
+			job.Args = makeCopyArgs(t, c.Bool(OPT_RSYNC), c.Bool(OPT_RECURSIVE), srcs, job.LocalDir+string(os.PathSeparator), true)
+		} else {
+			job.Args = makeCopyArgs(t, c.Bool(OPT_RSYNC), c.Bool(OPT_RECURSIVE), srcs, dest, false)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if c.Bool(OPT_DRYRUN) {
+		for _, j := range jobs {
+			fmt.Println(strings.Join(j.Args, " "))
+		}
+		return nil
+	}
+
+	if _, err := exec.LookPath(jobs[0].Args[0]); err != nil {
+		return ErrExit("not found %s command: %v", jobs[0].Args[0], err)
+	}
+
+	var mu sync.Mutex
+	succeeded := 0
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(j *CopyJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var out bytes.Buffer
+			err := runCopyJob(j, &out)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			prefix := "[" + j.Alias + "]"
+			printWithPrefix(os.Stderr, prefix, out.String())
+			if err != nil {
+				log.Printf("%s %s failed: %v", prefix, j.Target.InstanceId, err)
+				failed = append(failed, j.Alias)
+				return
+			}
+			log.Printf("%s %s done", prefix, j.Target.InstanceId)
+			succeeded++
+		}(j)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return ErrExit("succeeded %d, failed %d instances: %s", succeeded, len(failed), strings.Join(failed, ","))
+	}
+
+	return OkExit("succeeded %d instances", succeeded)
+}
+
+func runCopyJob(j *CopyJob, out *bytes.Buffer) error {
+	if j.LocalDir != "" {
+		if err := os.MkdirAll(j.LocalDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command(j.Args[0], j.Args[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	return cmd.Run()
+}
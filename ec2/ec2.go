@@ -14,6 +14,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 
 	"github.com/reiki4040/cstore"
 	"github.com/reiki4040/peco"
@@ -25,6 +26,9 @@ const (
 	EC2_STATE_ANY     = ""
 	EC2_STATE_RUNNING = "running"
 	EC2_STATE_STOPPED = "stopped"
+
+	MARKET_ON_DEMAND = "on-demand"
+	MARKET_SPOT      = "spot"
 )
 
 func MakeEC2Client(ctx context.Context, region string) (*ec2.Client, error) {
@@ -52,13 +56,19 @@ type ChoosableEC2 struct {
 	PublicIP     string
 	PrivateIP    string
 	IPv6         string
+	Lifecycle    string
+	// additional information in the picker.
+	Note string
 }
 
 func (e *ChoosableEC2) Choice() string {
 	w := new(tabwriter.Writer)
 	var b bytes.Buffer
 	w.Init(&b, 18, 0, 4, ' ', 0)
-	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s", e.InstanceId, e.Name, e.Status, e.InstanceType, e.PublicIP, e.PrivateIP, e.IPv6)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s", e.InstanceId, e.Name, e.Status, e.InstanceType, e.PublicIP, e.PrivateIP, e.IPv6, e.Lifecycle)
+	if e.Note != "" {
+		fmt.Fprintf(w, "\t%s", e.Note)
+	}
 	w.Flush()
 	return string(b.Bytes())
 }
@@ -68,7 +78,7 @@ func (e *ChoosableEC2) Value() string {
 }
 
 func (e *ChoosableEC2) String() string {
-	items := []string{e.InstanceId, e.Name, e.Status, e.InstanceType, e.PublicIP, e.PrivateIP, e.IPv6}
+	items := []string{e.InstanceId, e.Name, e.Status, e.InstanceType, e.PublicIP, e.PrivateIP, e.IPv6, e.Lifecycle}
 	return strings.Join(items, "\t")
 }
 
@@ -101,11 +111,20 @@ type EC2Handler struct {
 }
 
 func (h *EC2Handler) ChooseEC2(region, state string, reload bool) ([]string, error) {
+	return h.ChooseEC2WithNotes(region, state, reload, nil)
+}
+
+// choose instances with notes in the picker. notes is instance id to note map.
+func (h *EC2Handler) ChooseEC2WithNotes(region, state string, reload bool, notes map[string]string) ([]string, error) {
 	ec2list, err := h.LoadChoosableEC2List(region, state, reload)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, e := range ec2list {
+		e.Note = notes[e.InstanceId]
+	}
+
 	choices := ConvertChoosableList(ec2list)
 
 	chosens, err := peco.Choose("EC2", "select instances", "", choices)
@@ -129,13 +148,27 @@ func (r *EC2Handler) GetCacheStore(region string) (*cstore.CStore, error) {
 }
 
 func (r *EC2Handler) LoadChoosableEC2List(region, state string, reload bool) ([]*ChoosableEC2, error) {
-	var instances []types.Instance
+	instances, err := r.LoadInstances(region, reload)
+	if err != nil {
+		return nil, err
+	}
+
+	choices := ConvertChoosableEC2List(instances, state)
+	if len(choices) == 0 {
+		err := fmt.Errorf("there is no instance.")
+		return nil, err
+	}
+
+	return choices, nil
+}
+
+// load instances from cache. it loads from AWS and stores cache if there is no cache or reload.
+func (r *EC2Handler) LoadInstances(region string, reload bool) ([]types.Instance, error) {
 	cacheStore, _ := r.GetCacheStore(region)
 
 	is := Instances{}
-	if cErr := cacheStore.GetWithoutValidate(&is); cErr != nil || reload {
-		var err error
-		instances, err = GetInstances(region)
+	if cacheStore == nil || cacheStore.GetWithoutValidate(&is) != nil || reload {
+		instances, err := GetInstances(region)
 		if err != nil {
 			awsErr := fmt.Errorf("failed get instance: %s", err.Error())
 			return nil, awsErr
@@ -151,13 +184,22 @@ func (r *EC2Handler) LoadChoosableEC2List(region, state string, reload bool) ([]
 		}
 	}
 
-	choices := ConvertChoosableEC2List(is.Instances, state)
-	if len(choices) == 0 {
-		err := fmt.Errorf("there is no instance.")
+	return is.Instances, nil
+}
+
+// load instances only from cache without AWS access.
+func (r *EC2Handler) LoadCachedChoosableEC2List(region, state string) ([]*ChoosableEC2, error) {
+	cacheStore, err := r.GetCacheStore(region)
+	if err != nil {
 		return nil, err
 	}
 
-	return choices, nil
+	is := Instances{}
+	if err := cacheStore.GetWithoutValidate(&is); err != nil {
+		return nil, fmt.Errorf("failed load ec2 list cache: %v", err)
+	}
+
+	return ConvertChoosableEC2List(is.Instances, state), nil
 }
 
 func ConvertChoosableEC2List(instances []types.Instance, state string) []*ChoosableEC2 {
@@ -198,6 +240,13 @@ func convertChoosable(ins types.Instance) *ChoosableEC2 {
 			}
 		}
 	}
+
+	// on-demand instance has not lifecycle.
+	lifecycle := string(ins.InstanceLifecycle)
+	if lifecycle == "" {
+		lifecycle = MARKET_ON_DEMAND
+	}
+
 	c := &ChoosableEC2{
 		InstanceId:   convertNilString(ins.InstanceId),
 		Name:         nameTag,
@@ -206,6 +255,7 @@ func convertChoosable(ins types.Instance) *ChoosableEC2 {
 		PublicIP:     convertNilString(ins.PublicIpAddress),
 		PrivateIP:    convertNilString(ins.PrivateIpAddress),
 		IPv6:         ipv6,
+		Lifecycle:    lifecycle,
 	}
 
 	return c
@@ -238,6 +288,34 @@ func GetInstances(region string) ([]types.Instance, error) {
 	return instances, nil
 }
 
+// get not terminated instances that has the Name tag.
+func GetInstancesByName(ctx context.Context, cli *ec2.Client, name string) ([]types.Instance, error) {
+	param := &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:Name"),
+				Values: []string{name},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running", "stopping", "stopped"},
+			},
+		},
+	}
+
+	resp, err := cli.DescribeInstances(ctx, param)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]types.Instance, 0)
+	for _, r := range resp.Reservations {
+		instances = append(instances, r.Instances...)
+	}
+
+	return instances, nil
+}
+
 func GetInstancesFromId(ctx context.Context, cli *ec2.Client, ids ...string) ([]types.Instance, error) {
 	param := &ec2.DescribeInstancesInput{
 		InstanceIds: ids,
@@ -262,224 +340,196 @@ func GetInstancesFromId(ctx context.Context, cli *ec2.Client, ids ...string) ([]
 	return instances, nil
 }
 
-type ChoosableEIP struct {
-	AllocationId string
-	AssociateId  string
-	PublicIP     string
-	InstanceId   string
-	Name         string
-}
-
-func (c *ChoosableEIP) Choice() string {
-	return fmt.Sprintf("%s %s", c.PublicIP, c.Name)
+// *bool fields are nil if not specified. it keeps the launch template value.
+type Launcher struct {
+	AmiId              string
+	InstanceType       string
+	KeyName            string
+	SecurityGroupIds   []string
+	PublicIpEnabled    *bool
+	Ipv6Enabled        *bool
+	IamRoleName        *string
+	EbsDevices         []Ebs
+	EbsOptimized       *bool
+	PlacementGroupName string
+	UserData           string
+	Market             string
+	Spot               *SpotOptions
+	LaunchTemplate     *LaunchTemplate
+	ClientToken        string
+	// tagged to the instance and volumes at launch.
+	Tags []types.Tag
+
+	MetadataOptions       *MetadataOptions
+	DetailedMonitoring    *bool
+	TerminationProtection *bool
+	StopProtection        *bool
+	ShutdownBehavior      string
+	CpuCredits            string
+	Tenancy               string
+	AvailabilityZone      string
+	HostId                string
+	PrivateIp             string
+	SecondaryInterfaces   []NetworkInterface
+	Hibernation           bool
+}
+
+type MetadataOptions struct {
+	// nil is not specified. (the AMI default)
+	RequireIMDSv2        *bool
+	HopLimit             int32
+	InstanceMetadataTags bool
+}
+
+// secondary network interface. empty SubnetId means same subnet of the primary interface.
+type NetworkInterface struct {
+	SubnetId            string
+	SecurityGroupIds    []string
+	PrivateIp           string
+	Description         string
+	DeleteOnTermination bool
 }
 
-func (c *ChoosableEIP) Value() string {
-	return c.AllocationId
+type SpotOptions struct {
+	MaxPrice             string
+	InterruptionBehavior string
 }
 
-func ChooseEIP(ctx context.Context, region string) ([]*ChoosableEIP, error) {
-	EIPs, err := LoadEIPList(ctx, region)
-	if err != nil {
-		return nil, err
+// make market options for spot. on-demand returns nil.
+func (d *Launcher) marketOptions() *types.InstanceMarketOptionsRequest {
+	if d.Market != MARKET_SPOT {
+		return nil
 	}
 
-	choices := ConvertChoosableEIPList(EIPs)
-
-	chosens, err := peco.Choose("EIP", "select EIP", "", choices)
-	if err != nil {
-		return nil, err
+	spotOpts := &types.SpotMarketOptions{
+		SpotInstanceType: types.SpotInstanceTypeOneTime,
 	}
 
-	ids := make([]*ChoosableEIP, 0, len(chosens))
-	for _, c := range chosens {
-		if eip, ok := c.(*ChoosableEIP); ok {
-			ids = append(ids, eip)
+	if d.Spot != nil {
+		if d.Spot.MaxPrice != "" {
+			spotOpts.MaxPrice = aws.String(d.Spot.MaxPrice)
 		}
-	}
 
-	return ids, nil
-}
+		behavior := types.InstanceInterruptionBehavior(d.Spot.InterruptionBehavior)
+		if behavior != "" {
+			spotOpts.InstanceInterruptionBehavior = behavior
 
-func ConvertChoosableEIPList(eipList []*ChoosableEIP) []peco.Choosable {
-	choices := make([]peco.Choosable, 0, len(eipList))
-	for _, e := range eipList {
-		choices = append(choices, e)
-	}
-	return choices
-}
-
-func LoadEIPList(ctx context.Context, region string) ([]*ChoosableEIP, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-	if err != nil {
-		return nil, err
+			// stop and hibernate are supported only persistent request.
+			if behavior != types.InstanceInterruptionBehaviorTerminate {
+				spotOpts.SpotInstanceType = types.SpotInstanceTypePersistent
+			}
+		}
 	}
 
-	cli := ec2.NewFromConfig(cfg)
-	resp, err := cli.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{})
-	if err != nil {
-		return nil, err
+	return &types.InstanceMarketOptionsRequest{
+		MarketType:  types.MarketTypeSpot,
+		SpotOptions: spotOpts,
 	}
+}
 
-	instances, err := GetInstances(region)
-	if err != nil {
-		return nil, err
-	}
+// spot capacity error codes. these are able to retry with other instance type or on-demand.
+var spotCapacityErrorCodes = []string{
+	"InsufficientInstanceCapacity",
+	"SpotMaxPriceTooLow",
+	"MaxSpotInstanceCountExceeded",
+	"InsufficientCapacity",
+	"UnfulfillableCapacity",
+}
 
-	iMap := make(map[string]string, 0)
-	for _, i := range instances {
-		cEC2 := convertChoosable(i)
-		if cEC2.InstanceId != "" {
-			iMap[cEC2.InstanceId] = cEC2.Name
-		}
+func IsSpotCapacityError(err error) bool {
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		return false
 	}
 
-	cEIPs := make([]*ChoosableEIP, 0)
-	for _, addr := range resp.Addresses {
-		name, _ := iMap[convertNilString(addr.InstanceId)]
-		e := &ChoosableEIP{
-			AllocationId: convertNilString(addr.AllocationId),
-			AssociateId:  convertNilString(addr.AssociationId),
-			PublicIP:     convertNilString(addr.PublicIp),
-			InstanceId:   convertNilString(addr.InstanceId),
-			Name:         name,
+	for _, code := range spotCapacityErrorCodes {
+		if ae.ErrorCode() == code {
+			return true
 		}
-
-		cEIPs = append(cEIPs, e)
 	}
 
-	return cEIPs, nil
+	return false
 }
 
-func AssociateEIP(ctx context.Context, cli *ec2.Client, eipAllocId, instanceId string) (*string, error) {
-	params := &ec2.AssociateAddressInput{
-		AllocationId:       aws.String(eipAllocId),
-		AllowReassociation: aws.Bool(true),
-		InstanceId:         aws.String(instanceId),
+// why encrypted use *bool?
+// for modify root device volume size. cannot specify encrypted root device
+type Ebs struct {
+	DeviceName string
+	// nil is false without launch template.
+	DeleteOnTermination *bool
+	Encrypted           *bool
+	SizeGB              int64
+	VolumeType          string
+	Iops                int32
+	Throughput          int32
+	SnapshotId          string
+	KmsKeyId            string
+	// suppress the device that included in the AMI.
+	NoDevice bool
+}
+
+func (e *Ebs) blockDeviceMapping() types.BlockDeviceMapping {
+	if e.NoDevice {
+		return types.BlockDeviceMapping{
+			DeviceName: aws.String(e.DeviceName),
+			NoDevice:   aws.String(""),
+		}
 	}
-	resp, err := cli.AssociateAddress(ctx, params)
-
-	return resp.AssociationId, err
-}
 
-func AllocateEIP(ctx context.Context, cli *ec2.Client) (*string, *string, error) {
-	params := &ec2.AllocateAddressInput{
-		Domain: types.DomainTypeVpc,
+	ebs := &types.EbsBlockDevice{
+		DeleteOnTermination: e.DeleteOnTermination,
+		Encrypted:           e.Encrypted,
+		VolumeType:          types.VolumeType(e.VolumeType),
 	}
-	resp, err := cli.AllocateAddress(ctx, params)
-	return resp.AllocationId, resp.PublicIp, err
-}
 
-func DisassociateEIP(ctx context.Context, cli *ec2.Client, allocId string) error {
-	params := &ec2.DisassociateAddressInput{
-		AssociationId: aws.String(allocId),
+	// size 0 is same size of the snapshot.
+	if e.SizeGB > 0 {
+		ebs.VolumeSize = aws.Int32(int32(e.SizeGB))
 	}
-
-	// resp is empty struct
-	_, err := cli.DisassociateAddress(ctx, params)
-
-	return err
-}
-
-func ReleaseEIP(ctx context.Context, cli *ec2.Client, allocId string) error {
-	params := &ec2.ReleaseAddressInput{
-		AllocationId: aws.String(allocId),
+	if e.Iops > 0 {
+		ebs.Iops = aws.Int32(e.Iops)
 	}
-
-	// resp is empty struct
-	_, err := cli.ReleaseAddress(ctx, params)
-	return err
-}
-
-func GetEIPFromInstance(ctx context.Context, cli *ec2.Client, instanceId string) (*types.Address, error) {
-	params := &ec2.DescribeAddressesInput{
-		Filters: []types.Filter{
-			types.Filter{
-				Name: aws.String("instance-id"),
-				Values: []string{
-					instanceId,
-				},
-			},
-		},
+	if e.Throughput > 0 {
+		ebs.Throughput = aws.Int32(e.Throughput)
 	}
-	resp, err := cli.DescribeAddresses(ctx, params)
-	if err != nil {
-		return nil, err
+	if e.SnapshotId != "" {
+		ebs.SnapshotId = aws.String(e.SnapshotId)
 	}
-
-	if len(resp.Addresses) != 1 {
-		return nil, errors.New("this instance has not EIP.")
+	if e.KmsKeyId != "" {
+		ebs.KmsKeyId = aws.String(e.KmsKeyId)
 	}
 
-	address := resp.Addresses[0]
-	return &address, nil
+	return types.BlockDeviceMapping{
+		DeviceName: aws.String(e.DeviceName),
+		Ebs:        ebs,
+	}
 }
 
-func GetNotAssociateEIP(ctx context.Context, cli *ec2.Client) (*types.Address, error) {
-	params := &ec2.DescribeAddressesInput{}
+func (d *Launcher) Launch(ctx context.Context, cli *ec2.Client, subnetId string, count int, dryrun bool) (*ec2.RunInstancesOutput, error) {
+	return cli.RunInstances(ctx, d.RunInstancesInput(subnetId, count, dryrun))
+}
 
-	resp, err := cli.DescribeAddresses(ctx, params)
-	if err != nil {
-		return nil, err
+// block device mappings of the EBS devices. not specified delete on termination is false without launch template.
+func (d *Launcher) blockDeviceMappings() []types.BlockDeviceMapping {
+	if len(d.EbsDevices) == 0 {
+		return nil
 	}
 
-	if len(resp.Addresses) >= 1 {
-		for _, address := range resp.Addresses {
-			if address.InstanceId == nil {
-				return &address, nil
-			}
+	mappings := make([]types.BlockDeviceMapping, 0, len(d.EbsDevices))
+	for _, ebs := range d.EbsDevices {
+		m := ebs.blockDeviceMapping()
+		if d.LaunchTemplate == nil && m.Ebs != nil && m.Ebs.DeleteOnTermination == nil {
+			m.Ebs.DeleteOnTermination = aws.Bool(false)
 		}
-	}
-
-	return nil, nil
-}
 
-type Launcher struct {
-	AmiId              string
-	InstanceType       string
-	KeyName            string
-	SecurityGroupIds   []string
-	PublicIpEnabled    bool
-	Ipv6Enabled        bool
-	IamRoleName        *string
-	EbsDevices         []Ebs
-	EbsOptimized       bool
-	PlacementGroupName string
-	UserData           string
-}
+		mappings = append(mappings, m)
+	}
 
-// why encrypted use *bool?
-// for modify root device volume size. cannot specify encrypted root device
-type Ebs struct {
-	DeviceName          string
-	DeleteOnTermination bool
-	Encrypted           *bool
-	SizeGB              int64
-	VolumeType          string
+	return mappings
 }
 
-func (d *Launcher) Launch(ctx context.Context, cli *ec2.Client, subnetId string, count int, dryrun bool) (*ec2.RunInstancesOutput, error) {
-	var ebsMappings []types.BlockDeviceMapping
-	if len(d.EbsDevices) > 0 {
-		ebsMappings = make([]types.BlockDeviceMapping, 0, len(d.EbsDevices))
-		for _, ebs := range d.EbsDevices {
-			m := types.BlockDeviceMapping{
-				DeviceName: aws.String(ebs.DeviceName),
-				Ebs: &types.EbsBlockDevice{
-					DeleteOnTermination: aws.Bool(ebs.DeleteOnTermination),
-					Encrypted:           ebs.Encrypted,
-					//Iops:                aws.Int64(100),
-					//SnapshotId:          aws.String("String"),
-					VolumeSize: aws.Int32(int32(ebs.SizeGB)),
-					VolumeType: types.VolumeType(ebs.VolumeType),
-				},
-				//NoDevice:    aws.String("String"),
-				//VirtualName: aws.String("String"),
-			}
-
-			ebsMappings = append(ebsMappings, m)
-		}
-	}
+func (d *Launcher) RunInstancesInput(subnetId string, count int, dryrun bool) *ec2.RunInstancesInput {
+	ebsMappings := d.blockDeviceMappings()
 
 	var keyName *string
 	if d.KeyName != "" {
@@ -487,19 +537,33 @@ func (d *Launcher) Launch(ctx context.Context, cli *ec2.Client, subnetId string,
 	}
 
 	var ipv6count int32
-	if d.Ipv6Enabled {
+	if aws.ToBool(d.Ipv6Enabled) {
 		ipv6count = 1
 	} else {
 		ipv6count = 0
 	}
 
 	var placement *types.Placement
-	if d.PlacementGroupName != "" {
+	if d.PlacementGroupName != "" || d.AvailabilityZone != "" || d.HostId != "" || d.Tenancy != "" {
 		placement = &types.Placement{
-			GroupName: aws.String(d.PlacementGroupName),
+			Tenancy: types.Tenancy(d.Tenancy),
+		}
+		if d.PlacementGroupName != "" {
+			placement.GroupName = aws.String(d.PlacementGroupName)
+		}
+		if d.AvailabilityZone != "" {
+			placement.AvailabilityZone = aws.String(d.AvailabilityZone)
+		}
+		if d.HostId != "" {
+			placement.HostId = aws.String(d.HostId)
 		}
 	}
 
+	var privateIp *string
+	if d.PrivateIp != "" {
+		privateIp = aws.String(d.PrivateIp)
+	}
+
 	var userData string
 	if d.UserData != "" {
 		userData = base64.StdEncoding.EncodeToString([]byte(d.UserData))
@@ -511,10 +575,9 @@ func (d *Launcher) Launch(ctx context.Context, cli *ec2.Client, subnetId string,
 		MinCount:            aws.Int32(int32(count)),
 		BlockDeviceMappings: ebsMappings,
 		//AdditionalInfo: aws.String("String"),
-		//ClientToken:           aws.String("String"),
-		//DisableApiTermination: aws.Bool(true),
-		DryRun:       aws.Bool(dryrun),
-		EbsOptimized: aws.Bool(d.EbsOptimized),
+		DryRun:                aws.Bool(dryrun),
+		InstanceMarketOptions: d.marketOptions(),
+		EbsOptimized:          aws.Bool(aws.ToBool(d.EbsOptimized)),
 		IamInstanceProfile: &types.IamInstanceProfileSpecification{
 			//Arn: aws.String("arn:aws:iam::<aws_id>:instance-profile/sample_iamrole"),
 			Name: d.IamRoleName,
@@ -529,16 +592,14 @@ func (d *Launcher) Launch(ctx context.Context, cli *ec2.Client, subnetId string,
 		//},
 		//KernelId: aws.String("String"),
 		KeyName: keyName,
-		//Monitoring: &ec2.RunInstancesMonitoringEnabled{
-		//	Enabled: aws.Bool(true), // Required
-		//},
 		NetworkInterfaces: []types.InstanceNetworkInterfaceSpecification{
 			types.InstanceNetworkInterfaceSpecification{
-				AssociatePublicIpAddress: aws.Bool(d.PublicIpEnabled),
+				AssociatePublicIpAddress: aws.Bool(aws.ToBool(d.PublicIpEnabled)),
 				DeviceIndex:              aws.Int32(0),
 				SubnetId:                 aws.String(subnetId),
 				Groups:                   d.SecurityGroupIds,
 				Ipv6AddressCount:         aws.Int32(ipv6count),
+				PrivateIpAddress:         privateIp,
 			},
 		},
 		//	{ // Required
@@ -573,21 +634,352 @@ func (d *Launcher) Launch(ctx context.Context, cli *ec2.Client, subnetId string,
 		//	// More values...
 		//},
 		Placement: placement,
-		//Placement: &ec2.Placement{
-		//	Affinity:         aws.String("String"),
-		//	AvailabilityZone: aws.String("String"),
-		//	GroupName:        aws.String("String"),
-		//	HostId:           aws.String("String"),
-		//	Tenancy:          aws.String("Tenancy"),
-		//},
-		//PrivateIpAddress: aws.String("String"),
 		//RamdiskId:        aws.String("String"),
 		//SecurityGroupIds: p.SecurityGroupIds,
 		//SubnetId:         aws.String(p.SubnetId),
 		UserData: aws.String(userData),
 	}
 
-	return cli.RunInstances(ctx, params)
+	// same client token request does not launch new instance. it prevents duplicate launch when retrying.
+	if d.ClientToken != "" {
+		params.ClientToken = aws.String(d.ClientToken)
+	}
+
+	if len(d.Tags) > 0 {
+		params.TagSpecifications = []types.TagSpecification{
+			{ResourceType: types.ResourceTypeInstance, Tags: d.Tags},
+			{ResourceType: types.ResourceTypeVolume, Tags: d.Tags},
+		}
+	}
+
+	for i, ni := range d.SecondaryInterfaces {
+		niSubnetId := subnetId
+		if ni.SubnetId != "" {
+			niSubnetId = ni.SubnetId
+		}
+
+		spec := types.InstanceNetworkInterfaceSpecification{
+			DeviceIndex:         aws.Int32(int32(i + 1)),
+			SubnetId:            aws.String(niSubnetId),
+			Groups:              ni.SecurityGroupIds,
+			DeleteOnTermination: aws.Bool(ni.DeleteOnTermination),
+		}
+		if ni.PrivateIp != "" {
+			spec.PrivateIpAddress = aws.String(ni.PrivateIp)
+		}
+		if ni.Description != "" {
+			spec.Description = aws.String(ni.Description)
+		}
+
+		params.NetworkInterfaces = append(params.NetworkInterfaces, spec)
+	}
+
+	if d.MetadataOptions != nil {
+		params.MetadataOptions = d.MetadataOptions.request()
+	}
+
+	if aws.ToBool(d.DetailedMonitoring) {
+		params.Monitoring = &types.RunInstancesMonitoringEnabled{
+			Enabled: aws.Bool(true),
+		}
+	}
+
+	if aws.ToBool(d.TerminationProtection) {
+		params.DisableApiTermination = aws.Bool(true)
+	}
+
+	if aws.ToBool(d.StopProtection) {
+		params.DisableApiStop = aws.Bool(true)
+	}
+
+	params.InstanceInitiatedShutdownBehavior = types.ShutdownBehavior(d.ShutdownBehavior)
+
+	// cpu credits is only for burstable performance instances (T series).
+	if d.CpuCredits != "" {
+		params.CreditSpecification = &types.CreditSpecificationRequest{
+			CpuCredits: aws.String(d.CpuCredits),
+		}
+	}
+
+	if d.Hibernation {
+		params.HibernationOptions = &types.HibernationOptionsRequest{
+			Configured: aws.Bool(true),
+		}
+	}
+
+	if d.LaunchTemplate != nil {
+		d.overrideLaunchTemplate(params, subnetId)
+	}
+
+	return params
+}
+
+// launch template holds default values, so remove parameters that not specified in the launcher.
+func (d *Launcher) overrideLaunchTemplate(params *ec2.RunInstancesInput, subnetId string) {
+	params.LaunchTemplate = d.LaunchTemplate.Specification()
+
+	if d.AmiId == "" {
+		params.ImageId = nil
+	}
+
+	params.EbsOptimized = d.EbsOptimized
+
+	if d.IamRoleName == nil {
+		params.IamInstanceProfile = nil
+	}
+
+	if d.UserData == "" {
+		params.UserData = nil
+	}
+
+	// explicit false overrides true of the launch template.
+	if d.DetailedMonitoring != nil {
+		params.Monitoring = &types.RunInstancesMonitoringEnabled{
+			Enabled: d.DetailedMonitoring,
+		}
+	}
+	params.DisableApiTermination = d.TerminationProtection
+	params.DisableApiStop = d.StopProtection
+
+	if subnetId == "" && len(d.SecurityGroupIds) == 0 && d.PublicIpEnabled == nil && d.Ipv6Enabled == nil && d.PrivateIp == "" && len(d.SecondaryInterfaces) == 0 {
+		params.NetworkInterfaces = nil
+		return
+	}
+
+	ni := &params.NetworkInterfaces[0]
+	if subnetId == "" {
+		ni.SubnetId = nil
+	}
+	ni.AssociatePublicIpAddress = d.PublicIpEnabled
+	if d.Ipv6Enabled == nil {
+		ni.Ipv6AddressCount = nil
+	}
+}
+
+func (m *MetadataOptions) request() *types.InstanceMetadataOptionsRequest {
+	req := &types.InstanceMetadataOptionsRequest{}
+
+	if m.RequireIMDSv2 != nil {
+		if *m.RequireIMDSv2 {
+			req.HttpTokens = types.HttpTokensStateRequired
+		} else {
+			req.HttpTokens = types.HttpTokensStateOptional
+		}
+	}
+
+	if m.HopLimit > 0 {
+		req.HttpPutResponseHopLimit = aws.Int32(m.HopLimit)
+	}
+
+	if m.InstanceMetadataTags {
+		req.InstanceMetadataTags = types.InstanceMetadataTagsStateEnabled
+	}
+
+	return req
+}
+
+type LaunchTemplate struct {
+	Id      string
+	Name    string
+	Version string
+}
+
+func (t *LaunchTemplate) Specification() *types.LaunchTemplateSpecification {
+	spec := &types.LaunchTemplateSpecification{}
+	if t.Id != "" {
+		spec.LaunchTemplateId = aws.String(t.Id)
+	}
+	if t.Name != "" {
+		spec.LaunchTemplateName = aws.String(t.Name)
+	}
+	if t.Version != "" {
+		spec.Version = aws.String(t.Version)
+	}
+
+	return spec
+}
+
+// convert launcher to launch template data. subnetId is optional.
+func (d *Launcher) LaunchTemplateData(subnetId string, tags []types.Tag) *types.RequestLaunchTemplateData {
+	data := &types.RequestLaunchTemplateData{
+		InstanceType: types.InstanceType(d.InstanceType),
+	}
+
+	if d.AmiId != "" {
+		data.ImageId = aws.String(d.AmiId)
+	}
+
+	if d.KeyName != "" {
+		data.KeyName = aws.String(d.KeyName)
+	}
+
+	if aws.ToBool(d.EbsOptimized) {
+		data.EbsOptimized = aws.Bool(true)
+	}
+
+	if d.IamRoleName != nil {
+		data.IamInstanceProfile = &types.LaunchTemplateIamInstanceProfileSpecificationRequest{
+			Name: d.IamRoleName,
+		}
+	}
+
+	for _, bdm := range d.blockDeviceMappings() {
+		m := types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName: bdm.DeviceName,
+			NoDevice:   bdm.NoDevice,
+		}
+
+		if bdm.Ebs != nil {
+			m.Ebs = &types.LaunchTemplateEbsBlockDeviceRequest{
+				DeleteOnTermination: bdm.Ebs.DeleteOnTermination,
+				Encrypted:           bdm.Ebs.Encrypted,
+				Iops:                bdm.Ebs.Iops,
+				KmsKeyId:            bdm.Ebs.KmsKeyId,
+				SnapshotId:          bdm.Ebs.SnapshotId,
+				Throughput:          bdm.Ebs.Throughput,
+				VolumeSize:          bdm.Ebs.VolumeSize,
+				VolumeType:          bdm.Ebs.VolumeType,
+			}
+		}
+
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings, m)
+	}
+
+	ni := types.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+		AssociatePublicIpAddress: aws.Bool(aws.ToBool(d.PublicIpEnabled)),
+		DeviceIndex:              aws.Int32(0),
+		Groups:                   d.SecurityGroupIds,
+	}
+	if subnetId != "" {
+		ni.SubnetId = aws.String(subnetId)
+	}
+	if aws.ToBool(d.Ipv6Enabled) {
+		ni.Ipv6AddressCount = aws.Int32(1)
+	}
+	data.NetworkInterfaces = []types.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{ni}
+
+	for i, sni := range d.SecondaryInterfaces {
+		spec := types.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			DeviceIndex:         aws.Int32(int32(i + 1)),
+			Groups:              sni.SecurityGroupIds,
+			DeleteOnTermination: aws.Bool(sni.DeleteOnTermination),
+		}
+		if sni.SubnetId != "" {
+			spec.SubnetId = aws.String(sni.SubnetId)
+		} else if subnetId != "" {
+			spec.SubnetId = aws.String(subnetId)
+		}
+		if sni.Description != "" {
+			spec.Description = aws.String(sni.Description)
+		}
+
+		data.NetworkInterfaces = append(data.NetworkInterfaces, spec)
+	}
+
+	if d.PlacementGroupName != "" || d.AvailabilityZone != "" || d.HostId != "" || d.Tenancy != "" {
+		data.Placement = &types.LaunchTemplatePlacementRequest{
+			Tenancy: types.Tenancy(d.Tenancy),
+		}
+		if d.PlacementGroupName != "" {
+			data.Placement.GroupName = aws.String(d.PlacementGroupName)
+		}
+		if d.AvailabilityZone != "" {
+			data.Placement.AvailabilityZone = aws.String(d.AvailabilityZone)
+		}
+		if d.HostId != "" {
+			data.Placement.HostId = aws.String(d.HostId)
+		}
+	}
+
+	if d.MetadataOptions != nil {
+		req := d.MetadataOptions.request()
+		data.MetadataOptions = &types.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpTokens:              types.LaunchTemplateHttpTokensState(req.HttpTokens),
+			HttpPutResponseHopLimit: req.HttpPutResponseHopLimit,
+			InstanceMetadataTags:    types.LaunchTemplateInstanceMetadataTagsState(req.InstanceMetadataTags),
+		}
+	}
+
+	if aws.ToBool(d.DetailedMonitoring) {
+		data.Monitoring = &types.LaunchTemplatesMonitoringRequest{Enabled: aws.Bool(true)}
+	}
+
+	if aws.ToBool(d.TerminationProtection) {
+		data.DisableApiTermination = aws.Bool(true)
+	}
+
+	if aws.ToBool(d.StopProtection) {
+		data.DisableApiStop = aws.Bool(true)
+	}
+
+	data.InstanceInitiatedShutdownBehavior = types.ShutdownBehavior(d.ShutdownBehavior)
+
+	if d.CpuCredits != "" {
+		data.CreditSpecification = &types.CreditSpecificationRequest{CpuCredits: aws.String(d.CpuCredits)}
+	}
+
+	if d.Hibernation {
+		data.HibernationOptions = &types.LaunchTemplateHibernationOptionsRequest{Configured: aws.Bool(true)}
+	}
+
+	if d.UserData != "" {
+		data.UserData = aws.String(base64.StdEncoding.EncodeToString([]byte(d.UserData)))
+	}
+
+	if opts := d.marketOptions(); opts != nil {
+		data.InstanceMarketOptions = &types.LaunchTemplateInstanceMarketOptionsRequest{
+			MarketType: opts.MarketType,
+			SpotOptions: &types.LaunchTemplateSpotMarketOptionsRequest{
+				InstanceInterruptionBehavior: opts.SpotOptions.InstanceInterruptionBehavior,
+				MaxPrice:                     opts.SpotOptions.MaxPrice,
+				SpotInstanceType:             opts.SpotOptions.SpotInstanceType,
+			},
+		}
+	}
+
+	if len(tags) > 0 {
+		data.TagSpecifications = []types.LaunchTemplateTagSpecificationRequest{
+			{ResourceType: types.ResourceTypeInstance, Tags: tags},
+			{ResourceType: types.ResourceTypeVolume, Tags: tags},
+		}
+	}
+
+	return data
+}
+
+// create new version to the launch template. if the template does not exist, create it.
+// returns launch template id and created version number.
+func CreateLaunchTemplateVersion(ctx context.Context, cli *ec2.Client, templateName, description string, data *types.RequestLaunchTemplateData) (string, int64, error) {
+	params := &ec2.CreateLaunchTemplateVersionInput{
+		LaunchTemplateName: aws.String(templateName),
+		LaunchTemplateData: data,
+		VersionDescription: aws.String(description),
+	}
+
+	resp, err := cli.CreateLaunchTemplateVersion(ctx, params)
+	if err == nil {
+		v := resp.LaunchTemplateVersion
+		return convertNilString(v.LaunchTemplateId), aws.ToInt64(v.VersionNumber), nil
+	}
+
+	var ae smithy.APIError
+	if !errors.As(err, &ae) || ae.ErrorCode() != "InvalidLaunchTemplateName.NotFoundException" {
+		return "", 0, err
+	}
+
+	cParams := &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(templateName),
+		LaunchTemplateData: data,
+		VersionDescription: aws.String(description),
+	}
+
+	cResp, err := cli.CreateLaunchTemplate(ctx, cParams)
+	if err != nil {
+		return "", 0, err
+	}
+
+	t := cResp.LaunchTemplate
+	return convertNilString(t.LaunchTemplateId), aws.ToInt64(t.LatestVersionNumber), nil
 }
 
 func GetBlockDeviceMappings(ctx context.Context, cli *ec2.Client, instanceId string) ([]types.InstanceBlockDeviceMapping, error) {
@@ -0,0 +1,357 @@
+package ec2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/reiki4040/peco"
+)
+
+const (
+	TAG_EIP_ALLOCATED_AT = "rnzoo:allocated-at"
+	TAG_EIP_ALLOCATED_BY = "rnzoo:allocated-by"
+	TAG_EIP_PURPOSE      = "rnzoo:purpose"
+
+	// attach-eip --reuse --pool uses only the addresses in the pool.
+	TAG_EIP_POOL = "rnzoo:pool"
+
+	// the address that has this tag (except "false") is not released by eip gc.
+	TAG_EIP_RESERVED = "rnzoo:reserved"
+)
+
+// approximate hourly price (USD) of public IPv4 address. these are for estimation only.
+var PublicIPv4HourPrice = 0.005
+
+type ChoosableEIP struct {
+	AllocationId       string            `json:"allocation_id"`
+	PublicIP           string            `json:"public_ip"`
+	AssociateId        string            `json:"association_id"`
+	InstanceId         string            `json:"instance_id"`
+	Name               string            `json:"name"`
+	NetworkInterfaceId string            `json:"network_interface_id"`
+	PrivateIP          string            `json:"private_ip"`
+	NetworkBorderGroup string            `json:"network_border_group"`
+	Tags               map[string]string `json:"tags"`
+	Idle               bool              `json:"idle"`
+}
+
+func (c *ChoosableEIP) Choice() string {
+	w := new(tabwriter.Writer)
+	var b bytes.Buffer
+	w.Init(&b, 16, 0, 4, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s", c.PublicIP, c.AllocationId, c.InstanceId, c.Name, c.status())
+	w.Flush()
+	return string(b.Bytes())
+}
+
+func (c *ChoosableEIP) Value() string {
+	return c.AllocationId
+}
+
+func (c *ChoosableEIP) String() string {
+	items := []string{
+		c.AllocationId,
+		c.PublicIP,
+		c.AssociateId,
+		c.InstanceId,
+		c.Name,
+		c.NetworkInterfaceId,
+		c.PrivateIP,
+		c.NetworkBorderGroup,
+		c.TagString(),
+		c.status(),
+	}
+	return strings.Join(items, "\t")
+}
+
+func (c *ChoosableEIP) status() string {
+	if c.Idle {
+		return "idle"
+	}
+
+	return "in-use"
+}
+
+// tags as key=value,key=value order by key.
+func (c *ChoosableEIP) TagString() string {
+	tags := make([]string, 0, len(c.Tags))
+	for k, v := range c.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+
+	return strings.Join(tags, ",")
+}
+
+func (c *ChoosableEIP) Pool() string {
+	return c.Tags[TAG_EIP_POOL]
+}
+
+// reserved tag or pool address.
+func (c *ChoosableEIP) IsReserved() bool {
+	if c.Pool() != "" {
+		return true
+	}
+
+	v, ok := c.Tags[TAG_EIP_RESERVED]
+	return ok && v != "false"
+}
+
+// allocated time from the tag that rnzoo added. zero if it is unknown.
+func (c *ChoosableEIP) AllocatedAt() time.Time {
+	t, err := time.Parse(time.RFC3339, c.Tags[TAG_EIP_ALLOCATED_AT])
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// age of the address like 3d or 5h. "-" if it is unknown.
+func (c *ChoosableEIP) Age(now time.Time) string {
+	at := c.AllocatedAt()
+	if at.IsZero() {
+		return "-"
+	}
+
+	d := now.Sub(at)
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+
+	return fmt.Sprintf("%dh", int(d.Hours()))
+}
+
+// estimated monthly cost (USD) of the address. public IPv4 is charged even if it is in use.
+func (c *ChoosableEIP) MonthlyCost() float64 {
+	return PublicIPv4HourPrice * 24 * 30
+}
+
+func ConvertChoosableEIP(addr types.Address, names map[string]string) *ChoosableEIP {
+	tags := make(map[string]string, len(addr.Tags))
+	for _, t := range addr.Tags {
+		tags[convertNilString(t.Key)] = convertNilString(t.Value)
+	}
+
+	instanceId := convertNilString(addr.InstanceId)
+	return &ChoosableEIP{
+		AllocationId:       convertNilString(addr.AllocationId),
+		PublicIP:           convertNilString(addr.PublicIp),
+		AssociateId:        convertNilString(addr.AssociationId),
+		InstanceId:         instanceId,
+		Name:               names[instanceId],
+		NetworkInterfaceId: convertNilString(addr.NetworkInterfaceId),
+		PrivateIP:          convertNilString(addr.PrivateIpAddress),
+		NetworkBorderGroup: convertNilString(addr.NetworkBorderGroup),
+		Tags:               tags,
+		// the address that has no association (instance or ENI) is idle.
+		Idle: addr.AssociationId == nil,
+	}
+}
+
+func ChooseEIP(eips []*ChoosableEIP) ([]*ChoosableEIP, error) {
+	choices := ConvertChoosableEIPList(eips)
+
+	chosens, err := peco.Choose("EIP", "select EIP", "", choices)
+	if err != nil {
+		return nil, err
+	}
+
+	chosenEIPs := make([]*ChoosableEIP, 0, len(chosens))
+	for _, c := range chosens {
+		if eip, ok := c.(*ChoosableEIP); ok {
+			chosenEIPs = append(chosenEIPs, eip)
+		}
+	}
+
+	return chosenEIPs, nil
+}
+
+func ConvertChoosableEIPList(eipList []*ChoosableEIP) []peco.Choosable {
+	choices := make([]peco.Choosable, 0, len(eipList))
+	for _, e := range eipList {
+		choices = append(choices, e)
+	}
+	return choices
+}
+
+// load EIPs with the instance Name from the instance cache.
+func (r *EC2Handler) LoadEIPList(ctx context.Context, cli *ec2.Client, region string) ([]*ChoosableEIP, error) {
+	addresses, err := GetAddresses(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	instances, err := r.LoadInstances(region, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range instances {
+		cEC2 := convertChoosable(i)
+		if cEC2.InstanceId != "" {
+			names[cEC2.InstanceId] = cEC2.Name
+		}
+	}
+
+	cEIPs := make([]*ChoosableEIP, 0, len(addresses))
+	for _, addr := range addresses {
+		cEIPs = append(cEIPs, ConvertChoosableEIP(addr, names))
+	}
+
+	return cEIPs, nil
+}
+
+func GetAddresses(ctx context.Context, cli *ec2.Client) ([]types.Address, error) {
+	resp, err := cli.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Addresses, nil
+}
+
+func AssociateEIP(ctx context.Context, cli *ec2.Client, eipAllocId, instanceId string) (*string, error) {
+	params := &ec2.AssociateAddressInput{
+		AllocationId:       aws.String(eipAllocId),
+		AllowReassociation: aws.Bool(true),
+		InstanceId:         aws.String(instanceId),
+	}
+	resp, err := cli.AssociateAddress(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.AssociationId, nil
+}
+
+// associate to the ENI. privateIp empty is the primary private IP of the ENI.
+func AssociateEIPToNetworkInterface(ctx context.Context, cli *ec2.Client, eipAllocId, eniId, privateIp string) (*string, error) {
+	params := &ec2.AssociateAddressInput{
+		AllocationId:       aws.String(eipAllocId),
+		AllowReassociation: aws.Bool(true),
+		NetworkInterfaceId: aws.String(eniId),
+	}
+	if privateIp != "" {
+		params.PrivateIpAddress = aws.String(privateIp)
+	}
+
+	resp, err := cli.AssociateAddress(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.AssociationId, nil
+}
+
+// allocate new address with the tags and allocated time tag. DescribeAddresses does not return it.
+func AllocateEIP(ctx context.Context, cli *ec2.Client, tags []types.Tag) (*string, *string, error) {
+	tags = append(tags, types.Tag{Key: aws.String(TAG_EIP_ALLOCATED_AT), Value: aws.String(time.Now().UTC().Format(time.RFC3339))})
+	params := &ec2.AllocateAddressInput{
+		Domain: types.DomainTypeVpc,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeElasticIp,
+				Tags:         tags,
+			},
+		},
+	}
+	resp, err := cli.AllocateAddress(ctx, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp.AllocationId, resp.PublicIp, nil
+}
+
+func DisassociateEIP(ctx context.Context, cli *ec2.Client, allocId string) error {
+	params := &ec2.DisassociateAddressInput{
+		AssociationId: aws.String(allocId),
+	}
+
+	// resp is empty struct
+	_, err := cli.DisassociateAddress(ctx, params)
+
+	return err
+}
+
+func ReleaseEIP(ctx context.Context, cli *ec2.Client, allocId string) error {
+	params := &ec2.ReleaseAddressInput{
+		AllocationId: aws.String(allocId),
+	}
+
+	// resp is empty struct
+	_, err := cli.ReleaseAddress(ctx, params)
+	return err
+}
+
+// get the addresses that are associated to the ENIs of the instance.
+func GetEIPsFromInstance(ctx context.Context, cli *ec2.Client, ins types.Instance) ([]types.Address, error) {
+	eniIds := make([]string, 0, len(ins.NetworkInterfaces))
+	for _, ni := range ins.NetworkInterfaces {
+		eniIds = append(eniIds, convertNilString(ni.NetworkInterfaceId))
+	}
+
+	filter := types.Filter{
+		Name:   aws.String("network-interface-id"),
+		Values: eniIds,
+	}
+	if len(eniIds) == 0 {
+		filter = types.Filter{
+			Name:   aws.String("instance-id"),
+			Values: []string{convertNilString(ins.InstanceId)},
+		}
+	}
+
+	params := &ec2.DescribeAddressesInput{
+		Filters: []types.Filter{filter},
+	}
+	resp, err := cli.DescribeAddresses(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Addresses, nil
+}
+
+// get the address that has not associated in the pool.
+// if pool is empty, the addresses in any pool and reserved addresses are not used.
+func GetNotAssociateEIP(ctx context.Context, cli *ec2.Client, pool string) (*types.Address, error) {
+	params := &ec2.DescribeAddressesInput{}
+	if pool != "" {
+		params.Filters = []types.Filter{
+			{
+				Name:   aws.String("tag:" + TAG_EIP_POOL),
+				Values: []string{pool},
+			},
+		}
+	}
+
+	resp, err := cli.DescribeAddresses(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range resp.Addresses {
+		if address.AssociationId != nil {
+			continue
+		}
+
+		if pool == "" && ConvertChoosableEIP(address, nil).IsReserved() {
+			continue
+		}
+
+		return &address, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,299 @@
+package ec2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/reiki4040/cstore"
+	"github.com/reiki4040/peco"
+)
+
+const (
+	AMI_LIST_CACHE_PREFIX = "aws.images.cache."
+
+	AMI_OWNER_SELF = "self"
+)
+
+// find available images that match the name pattern. the pattern can use wildcard(*, ?).
+// the result is sorted by creation date, newest first.
+func FindImagesByName(ctx context.Context, cli *ec2.Client, namePattern string, owners ...string) ([]types.Image, error) {
+	params := &ec2.DescribeImagesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("name"),
+				Values: []string{namePattern},
+			},
+			{
+				Name:   aws.String("state"),
+				Values: []string{string(types.ImageStateAvailable)},
+			},
+		},
+		Owners: owners,
+	}
+
+	resp, err := cli.DescribeImages(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	images := resp.Images
+	// CreationDate is ISO 8601 format, so it can compare as string.
+	sort.SliceStable(images, func(i, j int) bool {
+		return convertNilString(images[i].CreationDate) > convertNilString(images[j].CreationDate)
+	})
+
+	return images, nil
+}
+
+// resolve a image by name pattern.
+// if multiple images matched, returns error unless latest is true.
+func ResolveImageByName(ctx context.Context, cli *ec2.Client, namePattern string, latest bool, owners ...string) (*types.Image, error) {
+	images, err := FindImagesByName(ctx, cli, namePattern, owners...)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(images) == 0:
+		return nil, fmt.Errorf("not found AMI that name is %s (owner: %s)", namePattern, strings.Join(owners, ","))
+	case len(images) > 1 && !latest:
+		ids := make([]string, 0, len(images))
+		for _, i := range images {
+			ids = append(ids, convertNilString(i.ImageId)+"("+convertNilString(i.Name)+")")
+		}
+		return nil, fmt.Errorf("%d AMIs matched %s, please specify latest or more strict name: %s", len(images), namePattern, strings.Join(ids, ","))
+	}
+
+	return &images[0], nil
+}
+
+type ChoosableAMI struct {
+	ImageId      string
+	Name         string
+	State        string
+	CreationDate string
+	OwnerId      string
+	SnapshotIds  []string
+}
+
+func (a *ChoosableAMI) Choice() string {
+	w := new(tabwriter.Writer)
+	var b bytes.Buffer
+	w.Init(&b, 18, 0, 4, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s", a.ImageId, a.Name, a.State, a.CreationDate, strings.Join(a.SnapshotIds, ","))
+	w.Flush()
+	return string(b.Bytes())
+}
+
+func (a *ChoosableAMI) Value() string {
+	return a.ImageId
+}
+
+func (a *ChoosableAMI) String() string {
+	items := []string{a.ImageId, a.Name, a.State, a.CreationDate, a.OwnerId, strings.Join(a.SnapshotIds, ",")}
+	return strings.Join(items, "\t")
+}
+
+type Images struct {
+	Images []types.Image `json:"images"`
+}
+
+// cache of the owner AMIs. namePattern is the DescribeImages name filter, empty is all.
+func (h *EC2Handler) GetImageCacheStore(region, owner, namePattern string) (*cstore.CStore, error) {
+	cacheFileName := AMI_LIST_CACHE_PREFIX + region + "." + owner
+	if namePattern != "" {
+		sum := sha256.Sum256([]byte(namePattern))
+		cacheFileName += "." + hex.EncodeToString(sum[:])[:16]
+	}
+	return h.Manager.New(cacheFileName+".json", cstore.JSON)
+}
+
+// load AMIs of the owner. namePattern filters the AMIs with wildcard(*, ?), empty is all.
+// own AMIs are few, so get all and filter on client side for sharing the cache that is refreshed after create/rm.
+// other owners (e.g. amazon) have too many AMIs, so filter with DescribeImages name filter.
+func (h *EC2Handler) LoadChoosableAMIList(ctx context.Context, region, owner, namePattern string, reload bool) ([]*ChoosableAMI, error) {
+	filterPattern := namePattern
+	if owner == AMI_OWNER_SELF {
+		filterPattern = ""
+	}
+
+	cacheStore, _ := h.GetImageCacheStore(region, owner, filterPattern)
+
+	is := Images{}
+	if cacheStore == nil || cacheStore.GetWithoutValidate(&is) != nil || reload {
+		cli, err := MakeEC2Client(ctx, region)
+		if err != nil {
+			return nil, err
+		}
+
+		images, err := GetImages(ctx, cli, filterPattern, owner)
+		if err != nil {
+			return nil, fmt.Errorf("failed get images: %s", err.Error())
+		}
+
+		is = Images{Images: images}
+		if cacheStore != nil {
+			err := cacheStore.SaveWithoutValidate(&is)
+			if err != nil {
+				// only warn message
+				fmt.Printf("warn: failed store AMI list cache: %s\n", err.Error())
+			}
+		}
+	}
+
+	choices := make([]*ChoosableAMI, 0, len(is.Images))
+	for _, i := range is.Images {
+		a := convertChoosableAMI(i)
+		if namePattern != "" && !matchWildcard(namePattern, a.Name) {
+			continue
+		}
+
+		choices = append(choices, a)
+	}
+
+	if len(choices) == 0 {
+		return nil, fmt.Errorf("there is no AMI.")
+	}
+
+	// newest first
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].CreationDate > choices[j].CreationDate
+	})
+
+	return choices, nil
+}
+
+// wildcard match like DescribeImages filter. * matches any characters including /.
+func matchWildcard(pattern, s string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+	ok, _ := regexp.MatchString("^"+re+"$", s)
+	return ok
+}
+
+func (h *EC2Handler) ChooseAMI(ctx context.Context, region, owner, namePattern string, reload bool) ([]*ChoosableAMI, error) {
+	amis, err := h.LoadChoosableAMIList(ctx, region, owner, namePattern, reload)
+	if err != nil {
+		return nil, err
+	}
+
+	choices := make([]peco.Choosable, 0, len(amis))
+	for _, a := range amis {
+		choices = append(choices, a)
+	}
+
+	chosens, err := peco.Choose("AMI", "select AMIs", "", choices)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]*ChoosableAMI, 0, len(chosens))
+	for _, c := range chosens {
+		if a, ok := c.(*ChoosableAMI); ok {
+			selected = append(selected, a)
+		}
+	}
+
+	return selected, nil
+}
+
+func convertChoosableAMI(i types.Image) *ChoosableAMI {
+	snapshotIds := make([]string, 0, len(i.BlockDeviceMappings))
+	for _, bdm := range i.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			snapshotIds = append(snapshotIds, *bdm.Ebs.SnapshotId)
+		}
+	}
+
+	return &ChoosableAMI{
+		ImageId:      convertNilString(i.ImageId),
+		Name:         convertNilString(i.Name),
+		State:        string(i.State),
+		CreationDate: convertNilString(i.CreationDate),
+		OwnerId:      convertNilString(i.OwnerId),
+		SnapshotIds:  snapshotIds,
+	}
+}
+
+// get images of the owners. namePattern is the name filter with wildcard(*, ?), empty is all.
+func GetImages(ctx context.Context, cli *ec2.Client, namePattern string, owners ...string) ([]types.Image, error) {
+	params := &ec2.DescribeImagesInput{Owners: owners}
+	if namePattern != "" {
+		params.Filters = []types.Filter{
+			{
+				Name:   aws.String("name"),
+				Values: []string{namePattern},
+			},
+		}
+	}
+
+	resp, err := cli.DescribeImages(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Images, nil
+}
+
+// create AMI from the instance. the AMI and the snapshots are tagged with same tags.
+func CreateImage(ctx context.Context, cli *ec2.Client, instanceId, name, description string, noReboot bool, tags []types.Tag) (string, error) {
+	params := &ec2.CreateImageInput{
+		InstanceId: aws.String(instanceId),
+		Name:       aws.String(name),
+		NoReboot:   aws.Bool(noReboot),
+	}
+
+	if description != "" {
+		params.Description = aws.String(description)
+	}
+
+	if len(tags) > 0 {
+		params.TagSpecifications = []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeImage,
+				Tags:         tags,
+			},
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags:         tags,
+			},
+		}
+	}
+
+	resp, err := cli.CreateImage(ctx, params)
+	if err != nil {
+		return "", err
+	}
+
+	return convertNilString(resp.ImageId), nil
+}
+
+// deregister AMI and delete the snapshots that the AMI used.
+// snapshots can not be deleted before deregistering the AMI.
+func DeregisterImage(ctx context.Context, cli *ec2.Client, imageId string, snapshotIds []string) error {
+	_, err := cli.DeregisterImage(ctx, &ec2.DeregisterImageInput{ImageId: aws.String(imageId)})
+	if err != nil {
+		return err
+	}
+
+	for _, snapId := range snapshotIds {
+		_, err := cli.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{SnapshotId: aws.String(snapId)})
+		if err != nil {
+			return fmt.Errorf("deregistered %s, but failed delete snapshot %s: %v", imageId, snapId, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,271 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// returns error that has not found resource ids.
+func checkNotFound(kind string, specified, found []string) error {
+	foundMap := make(map[string]bool, len(found))
+	for _, f := range found {
+		foundMap[f] = true
+	}
+
+	notFound := make([]string, 0)
+	for _, s := range specified {
+		if !foundMap[s] {
+			notFound = append(notFound, s)
+		}
+	}
+
+	if len(notFound) > 0 {
+		return fmt.Errorf("not found %s: %s", kind, strings.Join(notFound, ","))
+	}
+
+	return nil
+}
+
+func CheckImagesExist(ctx context.Context, cli *ec2.Client, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	resp, err := cli.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: ids})
+	if err != nil {
+		return err
+	}
+
+	found := make([]string, 0, len(resp.Images))
+	for _, i := range resp.Images {
+		found = append(found, convertNilString(i.ImageId))
+	}
+
+	return checkNotFound("AMI", ids, found)
+}
+
+func CheckSecurityGroupsExist(ctx context.Context, cli *ec2.Client, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	resp, err := cli.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: ids})
+	if err != nil {
+		return err
+	}
+
+	found := make([]string, 0, len(resp.SecurityGroups))
+	for _, sg := range resp.SecurityGroups {
+		found = append(found, convertNilString(sg.GroupId))
+	}
+
+	return checkNotFound("security group", ids, found)
+}
+
+func CheckSubnetsExist(ctx context.Context, cli *ec2.Client, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	resp, err := cli.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: ids})
+	if err != nil {
+		return err
+	}
+
+	found := make([]string, 0, len(resp.Subnets))
+	for _, s := range resp.Subnets {
+		found = append(found, convertNilString(s.SubnetId))
+	}
+
+	return checkNotFound("subnet", ids, found)
+}
+
+func CheckKeyPairExists(ctx context.Context, cli *ec2.Client, keyName string) error {
+	resp, err := cli.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{KeyNames: []string{keyName}})
+	if err != nil {
+		return err
+	}
+
+	found := make([]string, 0, len(resp.KeyPairs))
+	for _, k := range resp.KeyPairs {
+		found = append(found, convertNilString(k.KeyName))
+	}
+
+	return checkNotFound("key pair", []string{keyName}, found)
+}
+
+func CheckPlacementGroupExists(ctx context.Context, cli *ec2.Client, groupName string) error {
+	params := &ec2.DescribePlacementGroupsInput{
+		GroupNames: []string{groupName},
+	}
+
+	resp, err := cli.DescribePlacementGroups(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	found := make([]string, 0, len(resp.PlacementGroups))
+	for _, g := range resp.PlacementGroups {
+		found = append(found, convertNilString(g.GroupName))
+	}
+
+	return checkNotFound("placement group", []string{groupName}, found)
+}
+
+const (
+	REF_NAME_PREFIX = "name:"
+	REF_TAG_PREFIX  = "tag:"
+)
+
+// reference keys in tag: reference that are not tag.
+var refAttributeFilters = map[string]string{
+	"az":  "availability-zone",
+	"vpc": "vpc-id",
+}
+
+// resource reference that is resolved to id by Describe.
+//
+//	name:web
+//	tag:Tier=private,az=ap-northeast-1a
+type ResourceRef struct {
+	Name       string
+	Tags       map[string]string
+	Attributes map[string]string
+}
+
+func IsResourceRef(s string) bool {
+	return strings.HasPrefix(s, REF_NAME_PREFIX) || strings.HasPrefix(s, REF_TAG_PREFIX)
+}
+
+func ParseResourceRef(s string) (*ResourceRef, error) {
+	switch {
+	case strings.HasPrefix(s, REF_NAME_PREFIX):
+		name := strings.TrimPrefix(s, REF_NAME_PREFIX)
+		if name == "" {
+			return nil, fmt.Errorf("empty name reference: %s", s)
+		}
+
+		return &ResourceRef{Name: name}, nil
+	case strings.HasPrefix(s, REF_TAG_PREFIX):
+		ref := &ResourceRef{
+			Tags:       make(map[string]string),
+			Attributes: make(map[string]string),
+		}
+
+		for _, pair := range strings.Split(strings.TrimPrefix(s, REF_TAG_PREFIX), ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("invalid tag reference, it must be tag:Key=Value[,Key=Value]: %s", s)
+			}
+
+			if _, ok := refAttributeFilters[kv[0]]; ok {
+				ref.Attributes[kv[0]] = kv[1]
+			} else {
+				ref.Tags[kv[0]] = kv[1]
+			}
+		}
+
+		return ref, nil
+	default:
+		return nil, fmt.Errorf("invalid reference, it must start with %s or %s: %s", REF_NAME_PREFIX, REF_TAG_PREFIX, s)
+	}
+}
+
+func (r *ResourceRef) filters(nameFilter string) []types.Filter {
+	filters := make([]types.Filter, 0, len(r.Tags)+len(r.Attributes)+1)
+	if r.Name != "" {
+		filters = append(filters, types.Filter{
+			Name:   aws.String(nameFilter),
+			Values: []string{r.Name},
+		})
+	}
+
+	for k, v := range r.Tags {
+		filters = append(filters, types.Filter{
+			Name:   aws.String("tag:" + k),
+			Values: []string{v},
+		})
+	}
+
+	for k, v := range r.Attributes {
+		filters = append(filters, types.Filter{
+			Name:   aws.String(refAttributeFilters[k]),
+			Values: []string{v},
+		})
+	}
+
+	return filters
+}
+
+// returns error unless only one resource matched.
+func checkOneMatched(kind, ref string, matched []string) error {
+	switch len(matched) {
+	case 0:
+		return fmt.Errorf("not found %s that matches %s", kind, ref)
+	case 1:
+		return nil
+	default:
+		return fmt.Errorf("%d %ss matched %s, please specify more strict reference: %s", len(matched), kind, ref, strings.Join(matched, ","))
+	}
+}
+
+// resolve security group id. name: reference is group name. id is returned as it is.
+func ResolveSecurityGroupId(ctx context.Context, cli *ec2.Client, s string) (string, error) {
+	if !IsResourceRef(s) {
+		return s, nil
+	}
+
+	ref, err := ParseResourceRef(s)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := cli.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{Filters: ref.filters("group-name")})
+	if err != nil {
+		return "", err
+	}
+
+	matched := make([]string, 0, len(resp.SecurityGroups))
+	for _, sg := range resp.SecurityGroups {
+		matched = append(matched, convertNilString(sg.GroupId)+"("+convertNilString(sg.VpcId)+")")
+	}
+
+	if err := checkOneMatched("security group", s, matched); err != nil {
+		return "", err
+	}
+
+	return convertNilString(resp.SecurityGroups[0].GroupId), nil
+}
+
+// resolve subnet id. name: reference is Name tag. id is returned as it is.
+func ResolveSubnetId(ctx context.Context, cli *ec2.Client, s string) (string, error) {
+	if !IsResourceRef(s) {
+		return s, nil
+	}
+
+	ref, err := ParseResourceRef(s)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := cli.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{Filters: ref.filters("tag:Name")})
+	if err != nil {
+		return "", err
+	}
+
+	matched := make([]string, 0, len(resp.Subnets))
+	for _, sn := range resp.Subnets {
+		matched = append(matched, convertNilString(sn.SubnetId)+"("+convertNilString(sn.AvailabilityZone)+")")
+	}
+
+	if err := checkOneMatched("subnet", s, matched); err != nil {
+		return "", err
+	}
+
+	return convertNilString(resp.Subnets[0].SubnetId), nil
+}
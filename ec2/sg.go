@@ -0,0 +1,241 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type SecurityGroupRule struct {
+	RuleId      string
+	GroupId     string
+	GroupName   string
+	Egress      bool
+	Protocol    string
+	FromPort    int32
+	ToPort      int32
+	Peer        string
+	Description string
+	Tags        map[string]string
+}
+
+func (r *SecurityGroupRule) Direction() string {
+	if r.Egress {
+		return "outbound"
+	}
+
+	return "inbound"
+}
+
+func (r *SecurityGroupRule) ProtocolString() string {
+	if r.Protocol == "-1" {
+		return "all"
+	}
+
+	return r.Protocol
+}
+
+func (r *SecurityGroupRule) PortRange() string {
+	switch {
+	case r.Protocol == "-1" || (r.FromPort == -1 && r.ToPort == -1):
+		return "all"
+	case r.FromPort == r.ToPort:
+		return strconv.Itoa(int(r.FromPort))
+	default:
+		return fmt.Sprintf("%d-%d", r.FromPort, r.ToPort)
+	}
+}
+
+func (r *SecurityGroupRule) String() string {
+	items := []string{r.Direction(), r.GroupId, r.GroupName, r.ProtocolString(), r.PortRange(), r.Peer, r.RuleId, r.Description}
+	return strings.Join(items, "\t")
+}
+
+// get security group ids that attached to the instance (all network interfaces).
+func GetInstanceSecurityGroupIds(ctx context.Context, cli *ec2.Client, instanceId string) ([]string, error) {
+	insts, err := GetInstancesFromId(ctx, cli, instanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(insts) != 1 {
+		return nil, fmt.Errorf("not found instance: %s", instanceId)
+	}
+
+	found := make(map[string]bool)
+	ids := make([]string, 0)
+	add := func(groups []types.GroupIdentifier) {
+		for _, g := range groups {
+			id := convertNilString(g.GroupId)
+			if !found[id] {
+				found[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	add(insts[0].SecurityGroups)
+	for _, ni := range insts[0].NetworkInterfaces {
+		add(ni.Groups)
+	}
+
+	return ids, nil
+}
+
+// get security group id to name map.
+func GetSecurityGroupNames(ctx context.Context, cli *ec2.Client, ids ...string) (map[string]string, error) {
+	resp, err := cli.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: ids})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(resp.SecurityGroups))
+	for _, sg := range resp.SecurityGroups {
+		names[convertNilString(sg.GroupId)] = convertNilString(sg.GroupName)
+	}
+
+	return names, nil
+}
+
+// get security group rules with filters. inbound rules are first.
+func GetSecurityGroupRules(ctx context.Context, cli *ec2.Client, filters ...types.Filter) ([]*SecurityGroupRule, error) {
+	params := &ec2.DescribeSecurityGroupRulesInput{
+		Filters: filters,
+	}
+
+	rules := make([]*SecurityGroupRule, 0)
+	p := ec2.NewDescribeSecurityGroupRulesPaginator(cli, params)
+	for p.HasMorePages() {
+		resp, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range resp.SecurityGroupRules {
+			rules = append(rules, convertSecurityGroupRule(r))
+		}
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Egress != rules[j].Egress {
+			return !rules[i].Egress
+		}
+		if rules[i].GroupId != rules[j].GroupId {
+			return rules[i].GroupId < rules[j].GroupId
+		}
+		return rules[i].FromPort < rules[j].FromPort
+	})
+
+	return rules, nil
+}
+
+func GetSecurityGroupRulesByGroupIds(ctx context.Context, cli *ec2.Client, groupIds ...string) ([]*SecurityGroupRule, error) {
+	return GetSecurityGroupRules(ctx, cli, types.Filter{
+		Name:   aws.String("group-id"),
+		Values: groupIds,
+	})
+}
+
+func convertSecurityGroupRule(r types.SecurityGroupRule) *SecurityGroupRule {
+	peer := ""
+	switch {
+	case r.CidrIpv4 != nil:
+		peer = *r.CidrIpv4
+	case r.CidrIpv6 != nil:
+		peer = *r.CidrIpv6
+	case r.PrefixListId != nil:
+		peer = *r.PrefixListId
+	case r.ReferencedGroupInfo != nil:
+		peer = convertNilString(r.ReferencedGroupInfo.GroupId)
+	}
+
+	tags := make(map[string]string, len(r.Tags))
+	for _, t := range r.Tags {
+		tags[convertNilString(t.Key)] = convertNilString(t.Value)
+	}
+
+	return &SecurityGroupRule{
+		RuleId:      convertNilString(r.SecurityGroupRuleId),
+		GroupId:     convertNilString(r.GroupId),
+		Egress:      aws.ToBool(r.IsEgress),
+		Protocol:    convertNilString(r.IpProtocol),
+		FromPort:    aws.ToInt32(r.FromPort),
+		ToPort:      aws.ToInt32(r.ToPort),
+		Peer:        peer,
+		Description: convertNilString(r.Description),
+		Tags:        tags,
+	}
+}
+
+// add ingress rule with tags, and returns the rule id. IPv6 CIDR is added as IPv6 range.
+func AuthorizeIngress(ctx context.Context, cli *ec2.Client, groupId, protocol string, port int32, cidr, description string, tags []types.Tag) (string, error) {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+
+	perm := types.IpPermission{
+		IpProtocol: aws.String(protocol),
+		FromPort:   aws.Int32(port),
+		ToPort:     aws.Int32(port),
+	}
+
+	if ip.To4() == nil {
+		ipv6Range := types.Ipv6Range{
+			CidrIpv6: aws.String(cidr),
+		}
+		if description != "" {
+			ipv6Range.Description = aws.String(description)
+		}
+		perm.Ipv6Ranges = []types.Ipv6Range{ipv6Range}
+	} else {
+		ipRange := types.IpRange{
+			CidrIp: aws.String(cidr),
+		}
+		if description != "" {
+			ipRange.Description = aws.String(description)
+		}
+		perm.IpRanges = []types.IpRange{ipRange}
+	}
+
+	params := &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupId),
+		IpPermissions: []types.IpPermission{perm},
+	}
+
+	if len(tags) > 0 {
+		params.TagSpecifications = []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeSecurityGroupRule,
+				Tags:         tags,
+			},
+		}
+	}
+
+	resp, err := cli.AuthorizeSecurityGroupIngress(ctx, params)
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp.SecurityGroupRules) == 0 {
+		return "", nil
+	}
+
+	return convertNilString(resp.SecurityGroupRules[0].SecurityGroupRuleId), nil
+}
+
+func RevokeIngressRules(ctx context.Context, cli *ec2.Client, groupId string, ruleIds ...string) error {
+	_, err := cli.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+		GroupId:              aws.String(groupId),
+		SecurityGroupRuleIds: ruleIds,
+	})
+
+	return err
+}
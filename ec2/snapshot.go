@@ -0,0 +1,204 @@
+package ec2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/reiki4040/peco"
+)
+
+type ChoosableSnapshot struct {
+	SnapshotId  string
+	Name        string
+	State       string
+	VolumeId    string
+	SizeGB      int32
+	StartTime   time.Time
+	Description string
+}
+
+func (s *ChoosableSnapshot) Choice() string {
+	w := new(tabwriter.Writer)
+	var b bytes.Buffer
+	w.Init(&b, 18, 0, 4, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%dGB\t%s\t%s", s.SnapshotId, s.Name, s.State, s.VolumeId, s.SizeGB, s.StartTime.Format(time.RFC3339), s.Description)
+	w.Flush()
+	return string(b.Bytes())
+}
+
+func (s *ChoosableSnapshot) Value() string {
+	return s.SnapshotId
+}
+
+func (s *ChoosableSnapshot) String() string {
+	items := []string{s.SnapshotId, s.Name, s.State, s.VolumeId, strconv.Itoa(int(s.SizeGB)), s.StartTime.Format(time.RFC3339), s.Description}
+	return strings.Join(items, "\t")
+}
+
+// estimated monthly cost (USD) of the snapshot.
+// snapshots are incremental, so it is upper bound with the volume size.
+func (s *ChoosableSnapshot) MonthlyCost() float64 {
+	return SnapshotGBMonthPrice * float64(s.SizeGB)
+}
+
+func ConvertChoosableSnapshotList(snapshots []types.Snapshot) []*ChoosableSnapshot {
+	choices := make([]*ChoosableSnapshot, 0, len(snapshots))
+	for _, snap := range snapshots {
+		choices = append(choices, &ChoosableSnapshot{
+			SnapshotId:  convertNilString(snap.SnapshotId),
+			Name:        getNameTag(snap.Tags),
+			State:       string(snap.State),
+			VolumeId:    convertNilString(snap.VolumeId),
+			SizeGB:      aws.ToInt32(snap.VolumeSize),
+			StartTime:   aws.ToTime(snap.StartTime),
+			Description: convertNilString(snap.Description),
+		})
+	}
+
+	return choices
+}
+
+func ChooseSnapshot(snapshots []*ChoosableSnapshot) ([]*ChoosableSnapshot, error) {
+	choices := make([]peco.Choosable, 0, len(snapshots))
+	for _, s := range snapshots {
+		choices = append(choices, s)
+	}
+
+	chosens, err := peco.Choose("snapshot", "select snapshots", "", choices)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]*ChoosableSnapshot, 0, len(chosens))
+	for _, c := range chosens {
+		if s, ok := c.(*ChoosableSnapshot); ok {
+			selected = append(selected, s)
+		}
+	}
+
+	return selected, nil
+}
+
+// get own snapshots.
+func GetSnapshots(ctx context.Context, cli *ec2.Client) ([]types.Snapshot, error) {
+	params := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{AMI_OWNER_SELF},
+	}
+
+	snapshots := make([]types.Snapshot, 0)
+	p := ec2.NewDescribeSnapshotsPaginator(cli, params)
+	for p.HasMorePages() {
+		resp, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, resp.Snapshots...)
+	}
+
+	return snapshots, nil
+}
+
+func CreateSnapshot(ctx context.Context, cli *ec2.Client, volumeId, description string, tags []types.Tag) (string, error) {
+	params := &ec2.CreateSnapshotInput{
+		VolumeId: aws.String(volumeId),
+	}
+
+	if description != "" {
+		params.Description = aws.String(description)
+	}
+
+	if len(tags) > 0 {
+		params.TagSpecifications = []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags:         tags,
+			},
+		}
+	}
+
+	resp, err := cli.CreateSnapshot(ctx, params)
+	if err != nil {
+		return "", err
+	}
+
+	return convertNilString(resp.SnapshotId), nil
+}
+
+func DeleteSnapshot(ctx context.Context, cli *ec2.Client, snapshotId string) error {
+	_, err := cli.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{SnapshotId: aws.String(snapshotId)})
+	return err
+}
+
+// copy the snapshot to the region of destCli.
+func CopySnapshot(ctx context.Context, destCli *ec2.Client, srcRegion, snapshotId, description string, tags []types.Tag) (string, error) {
+	params := &ec2.CopySnapshotInput{
+		SourceRegion:     aws.String(srcRegion),
+		SourceSnapshotId: aws.String(snapshotId),
+	}
+
+	if description != "" {
+		params.Description = aws.String(description)
+	}
+
+	if len(tags) > 0 {
+		params.TagSpecifications = []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags:         tags,
+			},
+		}
+	}
+
+	resp, err := destCli.CopySnapshot(ctx, params)
+	if err != nil {
+		return "", err
+	}
+
+	return convertNilString(resp.SnapshotId), nil
+}
+
+// snapshots that the source volume was deleted and no own AMI uses.
+func FindOrphanedSnapshots(ctx context.Context, cli *ec2.Client, snapshots []types.Snapshot) ([]types.Snapshot, error) {
+	volumes, err := GetVolumes(ctx, cli, "")
+	if err != nil {
+		return nil, err
+	}
+
+	existVolumes := make(map[string]bool, len(volumes))
+	for _, v := range volumes {
+		existVolumes[convertNilString(v.VolumeId)] = true
+	}
+
+	images, err := GetImages(ctx, cli, "", AMI_OWNER_SELF)
+	if err != nil {
+		return nil, err
+	}
+
+	usedSnapshots := make(map[string]bool)
+	for _, i := range images {
+		for _, bdm := range i.BlockDeviceMappings {
+			if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+				usedSnapshots[*bdm.Ebs.SnapshotId] = true
+			}
+		}
+	}
+
+	orphaned := make([]types.Snapshot, 0)
+	for _, s := range snapshots {
+		if existVolumes[convertNilString(s.VolumeId)] || usedSnapshots[convertNilString(s.SnapshotId)] {
+			continue
+		}
+		orphaned = append(orphaned, s)
+	}
+
+	return orphaned, nil
+}
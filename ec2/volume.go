@@ -0,0 +1,209 @@
+package ec2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/reiki4040/peco"
+)
+
+// approximate monthly prices (USD, us-east-1). these are for estimation only.
+var (
+	EbsGBMonthPrices = map[types.VolumeType]float64{
+		types.VolumeTypeGp3:      0.08,
+		types.VolumeTypeGp2:      0.10,
+		types.VolumeTypeIo1:      0.125,
+		types.VolumeTypeIo2:      0.125,
+		types.VolumeTypeSt1:      0.045,
+		types.VolumeTypeSc1:      0.015,
+		types.VolumeTypeStandard: 0.05,
+	}
+
+	EbsProvisionedIopsMonthPrice = 0.065
+	Gp3IopsMonthPrice            = 0.005
+	Gp3ThroughputMonthPrice      = 0.04
+	SnapshotGBMonthPrice         = 0.05
+)
+
+const (
+	// gp3 includes these performance without additional cost.
+	GP3_BASE_IOPS       = 3000
+	GP3_BASE_THROUGHPUT = 125
+)
+
+type ChoosableVolume struct {
+	VolumeId     string
+	Name         string
+	State        string
+	SizeGB       int32
+	VolumeType   string
+	Iops         int32
+	Throughput   int32
+	AZ           string
+	InstanceId   string
+	InstanceName string
+	Device       string
+	CreateTime   time.Time
+}
+
+func (v *ChoosableVolume) Choice() string {
+	w := new(tabwriter.Writer)
+	var b bytes.Buffer
+	w.Init(&b, 18, 0, 4, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%dGB\t%s\t%s\t%s\t%s\t%s", v.VolumeId, v.Name, v.State, v.SizeGB, v.VolumeType, v.AZ, v.InstanceId, v.InstanceName, v.Device)
+	w.Flush()
+	return string(b.Bytes())
+}
+
+func (v *ChoosableVolume) Value() string {
+	return v.VolumeId
+}
+
+func (v *ChoosableVolume) String() string {
+	items := []string{v.VolumeId, v.Name, v.State, strconv.Itoa(int(v.SizeGB)), v.VolumeType, v.AZ, v.InstanceId, v.InstanceName, v.Device}
+	return strings.Join(items, "\t")
+}
+
+// estimated monthly cost (USD) of the volume.
+func (v *ChoosableVolume) MonthlyCost() float64 {
+	vt := types.VolumeType(v.VolumeType)
+	cost := EbsGBMonthPrices[vt] * float64(v.SizeGB)
+
+	switch vt {
+	case types.VolumeTypeIo1, types.VolumeTypeIo2:
+		cost += EbsProvisionedIopsMonthPrice * float64(v.Iops)
+	case types.VolumeTypeGp3:
+		if v.Iops > GP3_BASE_IOPS {
+			cost += Gp3IopsMonthPrice * float64(v.Iops-GP3_BASE_IOPS)
+		}
+		if v.Throughput > GP3_BASE_THROUGHPUT {
+			cost += Gp3ThroughputMonthPrice * float64(v.Throughput-GP3_BASE_THROUGHPUT)
+		}
+	}
+
+	return cost
+}
+
+// instanceNames is instance id to Name tag map for showing attached instance Name.
+func ConvertChoosableVolumeList(volumes []types.Volume, instanceNames map[string]string) []*ChoosableVolume {
+	choices := make([]*ChoosableVolume, 0, len(volumes))
+	for _, vol := range volumes {
+		v := &ChoosableVolume{
+			VolumeId:   convertNilString(vol.VolumeId),
+			Name:       getNameTag(vol.Tags),
+			State:      string(vol.State),
+			SizeGB:     aws.ToInt32(vol.Size),
+			VolumeType: string(vol.VolumeType),
+			Iops:       aws.ToInt32(vol.Iops),
+			Throughput: aws.ToInt32(vol.Throughput),
+			AZ:         convertNilString(vol.AvailabilityZone),
+			CreateTime: aws.ToTime(vol.CreateTime),
+		}
+
+		// multi attach volume shows the first attachment.
+		if len(vol.Attachments) > 0 {
+			v.InstanceId = convertNilString(vol.Attachments[0].InstanceId)
+			v.InstanceName = instanceNames[v.InstanceId]
+			v.Device = convertNilString(vol.Attachments[0].Device)
+		}
+
+		choices = append(choices, v)
+	}
+
+	return choices
+}
+
+func ChooseVolume(volumes []*ChoosableVolume) ([]*ChoosableVolume, error) {
+	choices := make([]peco.Choosable, 0, len(volumes))
+	for _, v := range volumes {
+		choices = append(choices, v)
+	}
+
+	chosens, err := peco.Choose("EBS", "select volumes", "", choices)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]*ChoosableVolume, 0, len(chosens))
+	for _, c := range chosens {
+		if v, ok := c.(*ChoosableVolume); ok {
+			selected = append(selected, v)
+		}
+	}
+
+	return selected, nil
+}
+
+func getNameTag(tags []types.Tag) string {
+	for _, t := range tags {
+		if convertNilString(t.Key) == "Name" {
+			return convertNilString(t.Value)
+		}
+	}
+
+	return ""
+}
+
+// get volumes. state empty is all states.
+func GetVolumes(ctx context.Context, cli *ec2.Client, state types.VolumeState) ([]types.Volume, error) {
+	params := &ec2.DescribeVolumesInput{}
+	if state != "" {
+		params.Filters = []types.Filter{
+			{
+				Name:   aws.String("status"),
+				Values: []string{string(state)},
+			},
+		}
+	}
+
+	volumes := make([]types.Volume, 0)
+	p := ec2.NewDescribeVolumesPaginator(cli, params)
+	for p.HasMorePages() {
+		resp, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, resp.Volumes...)
+	}
+
+	return volumes, nil
+}
+
+func AttachVolume(ctx context.Context, cli *ec2.Client, volumeId, instanceId, device string) error {
+	_, err := cli.AttachVolume(ctx, &ec2.AttachVolumeInput{
+		VolumeId:   aws.String(volumeId),
+		InstanceId: aws.String(instanceId),
+		Device:     aws.String(device),
+	})
+
+	return err
+}
+
+func DetachVolume(ctx context.Context, cli *ec2.Client, volumeId string, force bool) error {
+	_, err := cli.DetachVolume(ctx, &ec2.DetachVolumeInput{
+		VolumeId: aws.String(volumeId),
+		Force:    aws.Bool(force),
+	})
+
+	return err
+}
+
+// resize the volume. EBS volume can not shrink.
+// the file system is not extended, please extend it in the instance after modification.
+func ResizeVolume(ctx context.Context, cli *ec2.Client, volumeId string, sizeGB int32) error {
+	_, err := cli.ModifyVolume(ctx, &ec2.ModifyVolumeInput{
+		VolumeId: aws.String(volumeId),
+		Size:     aws.Int32(sizeGB),
+	})
+
+	return err
+}
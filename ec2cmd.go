@@ -3,10 +3,10 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -31,8 +31,8 @@ const (
 
        rnzoo ec2list -r ap-northeast-1
 
-	   i-11111111	Name tag Web server1	stopped	t2.micro	54.X.X.X	10.Y.Y.Y	xxxx:xxxx::xxxx
-	   i-22222222	Name tag Web server2	running	m3.large	52.X.X.x	10.Y.Y.y	xxxx:xxxx::yyyy
+	   i-11111111	Name tag Web server1	stopped	t2.micro	54.X.X.X	10.Y.Y.Y	xxxx:xxxx::xxxx	on-demand
+	   i-22222222	Name tag Web server2	running	m3.large	52.X.X.x	10.Y.Y.y	xxxx:xxxx::yyyy	spot
        ...
 
      you can set default region by AWS_REGION environment variable.
@@ -59,6 +59,98 @@ const (
 
 	EC2RUN_DESC = `
 	run EC2 instances with configuration yaml file.
+
+	spot instance is launched when set market: spot in the config.
+	if spot capacity is not available, retry with spot.instance_types in order.
+	and if set spot.fallback_on_demand or --fallback-on-demand option, launch on-demand instance at last.
+
+	launch from EC2 launch template when set launch_template (id or name, and version) in the config.
+	then only the properties that set in the config or options overwrite the template (explicit false too, e.g. ebs_optimized: false).
+
+	--export-template option stores the config as new version of the launch template (create it if not exists).
+
+	    rnzoo run --export-template web-template --specify-name web config.yml
+
+	all configs are validated before launching. --validate option only validates configs (same as lint subcommand).
+
+	string values in the config are rendered as template with vars and symbol before launching.
+
+	    - name: web
+	      include: [common.yml]
+	      vars:
+	        env: prod
+	      ami_id: "{{.Vars.ami}}"
+	      tags:
+	        - key: env
+	          value: "{{.Vars.env}}"
+
+	include merges the fragment files under the config (the config values take priority).
+	--env staging merges staging.yml in same directory over the config.
+	--var key=value overwrites vars.
+
+	--plan option shows instances that would be launched without AWS access (except resolving ami and references).
+	it compares Name tag with the ec2list cache, please update cache with 'rnzoo ls -f' before plan.
+
+	each launch has deterministic client token from config name, launch entry and symbol,
+	so re-running after network error does not launch duplicate instances.
+	--ensure option launches only the instances that there is no same Name tag instance (not terminated).
+
+	--atomic option terminates the instances that launched in this run when some launch failed.
+	it confirms before termination, --yes option terminates without confirming.
+
+	user data is rendered as template with {{.Name}}, {{.Symbol}}, {{.Sequence}} and {{.Vars.key}} for each launch.
+	if the script has {{ }} for other tools, user_data_template: false or raw: true in user_data_parts disables it.
+	user_data, user_data_file and user_data_parts are assembled to cloud-init multipart MIME if there are multiple parts.
+	the user data that over 16KB is gzip compressed. if it is still over 16KB, failed before launching.
+
+	    user_data_file: init.sh
+	    user_data_parts:
+	      - file: cloud-config.yml
+	        content_type: text/cloud-config
+	      - file: docker-status.sh
+	        raw: true
+
+	ami resolves AMI at launch time instead of ami_id, by SSM parameter or image name.
+	the resolved AMI is recorded to rnzoo:ami-source and rnzoo:ami-id tags.
+
+	    ami: ssm:/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64
+	    ami:
+	      name: our-base-*
+	      owner: self
+	      latest: true
+
+	security_group_ids, subnet_id and iam_role_name accept name: or tag: reference instead of id.
+	the references are resolved before launching, and failed if there are zero or multiple matches.
+	az= and vpc= in tag: reference are availability zone and VPC id conditions.
+
+	    security_group_ids: ["name:web"]
+	    launches:
+	      - subnet_id: tag:Tier=private,az=ap-northeast-1a
+	`
+	MOVEEIP_DESC = `
+	reassociate EIP(allow reassociate) to other instance.
+
+	select the EIP and the instance with peco, or specify them with options.
+	--eip is public IP or allocation id, --to is instance id or Name tag (must be one instance).
+
+	    rnzoo move-eip --eip 203.0.113.10 --to web-2
+
+	for the instance that has multiple ENIs/private IPs, specify --to-eni and/or --private-ip.
+
+	--swap exchanges the EIPs. the EIP of the target instance is moved to the previous place of the selected EIP.
+	the target's EIP is moved at first, so the target has no EIP for a moment.
+	without --swap, the EIP of the target instance is replaced and left not associated (it is charged).
+
+	    rnzoo move-eip --swap --eip 203.0.113.10 --to web-2
+	`
+	DETACHEIP_DESC = `
+	disassociate EIP and release it.
+
+	all EIPs that associated to the ENIs of the instance are listed.
+	if the instance has multiple EIPs, select them with peco, --eip or --all.
+	reserved addresses (rnzoo:reserved tag) and pool addresses are only disassociated, not released.
+
+	    rnzoo detach-eip --instance-id i-xxxxxxxx --all --without-release
 	`
 	EC2TERMINATE_DESC = `
 	terminate EC2 instances.
@@ -230,6 +322,55 @@ var commandEc2run = cli.Command{
 			Name:  OPT_SPECIFY_NAME,
 			Usage: "specify config name in yaml",
 		},
+		&cli.BoolFlag{
+			Name:  OPT_FALLBACK_ON_DEMAND,
+			Usage: "launch on-demand instance when spot capacity is not available.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_EXPORT_TEMPLATE,
+			Usage: "store the config as new version of specified launch template instead of run instances.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_VALIDATE,
+			Usage: "validate config files strictly and exit without launching.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_CHECK_AWS,
+			Usage: "with --validate, check the resources in the config exist on AWS.",
+		},
+		&cli.StringSliceFlag{
+			Name:  OPT_VAR,
+			Usage: "overwrite config vars. key=value",
+		},
+		&cli.StringFlag{
+			Name:  OPT_ENV,
+			Usage: "merge environment overlay <env>.yml that in same directory of the config.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_PLAN,
+			Usage: "show the instances and RunInstances requests that would be launched, without AWS access except resolving ami and references.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_JSON,
+			Usage: "output plan with JSON format.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_ENSURE,
+			Usage: "launch only missing instances that there is no same Name tag instance.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_WITHOUT_CLIENT_TOKEN,
+			Usage: "does not set client token. (always launch new instances)",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_ATOMIC,
+			Usage: "terminate launched instances in this run when some launch failed.",
+		},
+		&cli.BoolFlag{
+			Name:    OPT_YES,
+			Aliases: []string{"y"},
+			Usage:   "with --atomic, terminate without confirming.",
+		},
 	},
 }
 var commandEc2terminate = cli.Command{
@@ -617,19 +758,42 @@ func doEc2type(c *cli.Context) error {
 }
 
 type EC2RunConfig struct {
-	Name               string `yaml:"name"`
-	AmiId              string `yaml:"ami_id"`
-	IamRoleName        string `yaml:"iam_role_name"`
-	PlacementGroupName string `yaml:"placement_group_name" `
-	PublicIpEnabled    bool   `yaml:"public_ip_enabled"`
-	Ipv6Enabled        bool   `yaml:"ipv6_enabled"`
-	Type               string `yaml:"instance_type"`
-	KeyPair            string `yaml:"key_pair"`
+	Name               string     `yaml:"name"`
+	AmiId              string     `yaml:"ami_id"`
+	Ami                *EC2RunAmi `yaml:"ami,omitempty"`
+	IamRoleName        string     `yaml:"iam_role_name"`
+	PlacementGroupName string     `yaml:"placement_group_name" `
+	PublicIpEnabled    *bool      `yaml:"public_ip_enabled"`
+	Ipv6Enabled        *bool      `yaml:"ipv6_enabled"`
+	Type               string     `yaml:"instance_type"`
+	KeyPair            string     `yaml:"key_pair"`
 
 	EbsDevices   []EC2RunEbs `yaml:"ebs_volumes"`
-	EbsOptimized bool        `yaml:"ebs_optimized"`
+	EbsOptimized *bool       `yaml:"ebs_optimized"`
+
+	UserData      string               `yaml:"user_data"`
+	UserDataFile  string               `yaml:"user_data_file,omitempty"`
+	UserDataParts []EC2RunUserDataPart `yaml:"user_data_parts,omitempty"`
+	// false does not render user data as template. (default true)
+	UserDataTemplate *bool `yaml:"user_data_template,omitempty"`
+
+	Vars    map[string]string `yaml:"vars,omitempty"`
+	Include []string          `yaml:"include,omitempty"`
+
+	Market string      `yaml:"market,omitempty"`
+	Spot   *EC2RunSpot `yaml:"spot,omitempty"`
+
+	LaunchTemplate *EC2RunLaunchTemplate `yaml:"launch_template,omitempty"`
 
-	UserData string `yaml:"user_data"`
+	MetadataOptions       *EC2RunMetadataOptions   `yaml:"metadata_options,omitempty"`
+	DetailedMonitoring    *bool                    `yaml:"detailed_monitoring,omitempty"`
+	TerminationProtection *bool                    `yaml:"termination_protection,omitempty"`
+	StopProtection        *bool                    `yaml:"stop_protection,omitempty"`
+	ShutdownBehavior      string                   `yaml:"shutdown_behavior,omitempty"`
+	CpuCredits            string                   `yaml:"cpu_credits,omitempty"`
+	Placement             *EC2RunPlacement         `yaml:"placement,omitempty"`
+	SecondaryInterfaces   []EC2RunNetworkInterface `yaml:"secondary_network_interfaces,omitempty"`
+	Hibernation           bool                     `yaml:"hibernation,omitempty"`
 
 	Tags             []EC2RunConfigTag    `yaml:"tags"`
 	SecurityGroupIds []string             `yaml:"security_group_ids"`
@@ -638,10 +802,89 @@ type EC2RunConfig struct {
 
 type EC2RunEbs struct {
 	DeviceName          string `yaml:"device_name"`
-	DeleteOnTermination bool   `yaml:"delete_on_termination"`
+	DeleteOnTermination *bool  `yaml:"delete_on_termination"`
 	Encrypted           *bool  `yaml:"encrypted"`
 	SizeGB              int64  `yaml:"size_gb"`
 	VolumeType          string `yaml:"volume_type"`
+	Iops                int32  `yaml:"iops,omitempty"`
+	Throughput          int32  `yaml:"throughput,omitempty"`
+	SnapshotId          string `yaml:"snapshot_id,omitempty"`
+	KmsKeyId            string `yaml:"kms_key_id,omitempty"`
+	NoDevice            bool   `yaml:"no_device,omitempty"`
+}
+
+type EC2RunSpot struct {
+	MaxPrice             string   `yaml:"max_price,omitempty"`
+	InterruptionBehavior string   `yaml:"interruption_behavior,omitempty"`
+	InstanceTypes        []string `yaml:"instance_types,omitempty"`
+	FallbackOnDemand     bool     `yaml:"fallback_on_demand,omitempty"`
+}
+
+func (c *EC2RunConfig) validateMarket() error {
+	switch c.Market {
+	case "", myec2.MARKET_ON_DEMAND, myec2.MARKET_SPOT:
+	default:
+		return fmt.Errorf("unknown market: %s (allowed %s or %s)", c.Market, myec2.MARKET_ON_DEMAND, myec2.MARKET_SPOT)
+	}
+
+	if c.Spot == nil {
+		return nil
+	}
+
+	if c.Market != myec2.MARKET_SPOT {
+		return fmt.Errorf("spot options are set, but market is not %s", myec2.MARKET_SPOT)
+	}
+
+	switch types.InstanceInterruptionBehavior(c.Spot.InterruptionBehavior) {
+	case "", types.InstanceInterruptionBehaviorTerminate, types.InstanceInterruptionBehaviorStop, types.InstanceInterruptionBehaviorHibernate:
+	default:
+		return fmt.Errorf("unknown spot interruption_behavior: %s (allowed terminate, stop or hibernate)", c.Spot.InterruptionBehavior)
+	}
+
+	return nil
+}
+
+type EC2RunMetadataOptions struct {
+	// nil keeps the AMI default. (IMDSv2 may be required)
+	RequireIMDSv2        *bool `yaml:"require_imdsv2,omitempty"`
+	HopLimit             int32 `yaml:"hop_limit,omitempty"`
+	InstanceMetadataTags bool  `yaml:"instance_metadata_tags,omitempty"`
+}
+
+type EC2RunPlacement struct {
+	Tenancy          string `yaml:"tenancy,omitempty"`
+	AvailabilityZone string `yaml:"availability_zone,omitempty"`
+	HostId           string `yaml:"host_id,omitempty"`
+}
+
+type EC2RunNetworkInterface struct {
+	SubnetId            string   `yaml:"subnet_id,omitempty"`
+	SecurityGroupIds    []string `yaml:"security_group_ids,omitempty"`
+	PrivateIp           string   `yaml:"private_ip,omitempty"`
+	Description         string   `yaml:"description,omitempty"`
+	DeleteOnTermination bool     `yaml:"delete_on_termination"`
+}
+
+type EC2RunLaunchTemplate struct {
+	Id      string `yaml:"id,omitempty"`
+	Name    string `yaml:"name,omitempty"`
+	Version string `yaml:"version,omitempty"`
+}
+
+func (c *EC2RunConfig) validateLaunchTemplate() error {
+	if c.LaunchTemplate == nil {
+		return nil
+	}
+
+	if c.LaunchTemplate.Id == "" && c.LaunchTemplate.Name == "" {
+		return fmt.Errorf("launch_template requires id or name")
+	}
+
+	if c.LaunchTemplate.Id != "" && c.LaunchTemplate.Name != "" {
+		return fmt.Errorf("launch_template can not set both id and name")
+	}
+
+	return nil
 }
 
 type EC2RunConfigTag struct {
@@ -653,6 +896,7 @@ type EC2RunConfigLaunch struct {
 	SubnetId        string `yaml:"subnet_id"`
 	OutputTemplate  string `yaml:"output_template"`
 	OverWriteType   string `yaml:"instance_type,omitempty"`
+	PrivateIp       string `yaml:"private_ip,omitempty"`
 }
 
 func (c *EC2RunConfig) genLauncher() *myec2.Launcher {
@@ -674,6 +918,11 @@ func (c *EC2RunConfig) genLauncher() *myec2.Launcher {
 			Encrypted:           e.Encrypted,
 			SizeGB:              e.SizeGB,
 			VolumeType:          e.VolumeType,
+			Iops:                e.Iops,
+			Throughput:          e.Throughput,
+			SnapshotId:          e.SnapshotId,
+			KmsKeyId:            e.KmsKeyId,
+			NoDevice:            e.NoDevice,
 		}
 
 		ebss = append(ebss, ebs)
@@ -691,18 +940,129 @@ func (c *EC2RunConfig) genLauncher() *myec2.Launcher {
 		EbsOptimized:       c.EbsOptimized,
 		PlacementGroupName: c.PlacementGroupName,
 		UserData:           c.UserData,
+		Market:             c.Market,
+
+		DetailedMonitoring:    c.DetailedMonitoring,
+		TerminationProtection: c.TerminationProtection,
+		StopProtection:        c.StopProtection,
+		ShutdownBehavior:      c.ShutdownBehavior,
+		CpuCredits:            c.CpuCredits,
+		Hibernation:           c.Hibernation,
+	}
+
+	if c.MetadataOptions != nil {
+		l.MetadataOptions = &myec2.MetadataOptions{
+			RequireIMDSv2:        c.MetadataOptions.RequireIMDSv2,
+			HopLimit:             c.MetadataOptions.HopLimit,
+			InstanceMetadataTags: c.MetadataOptions.InstanceMetadataTags,
+		}
+	}
+
+	if c.Placement != nil {
+		l.Tenancy = c.Placement.Tenancy
+		l.AvailabilityZone = c.Placement.AvailabilityZone
+		l.HostId = c.Placement.HostId
+	}
+
+	for _, ni := range c.SecondaryInterfaces {
+		l.SecondaryInterfaces = append(l.SecondaryInterfaces, myec2.NetworkInterface{
+			SubnetId:            ni.SubnetId,
+			SecurityGroupIds:    ni.SecurityGroupIds,
+			PrivateIp:           ni.PrivateIp,
+			Description:         ni.Description,
+			DeleteOnTermination: ni.DeleteOnTermination,
+		})
+	}
+
+	if c.LaunchTemplate != nil {
+		l.LaunchTemplate = &myec2.LaunchTemplate{
+			Id:      c.LaunchTemplate.Id,
+			Name:    c.LaunchTemplate.Name,
+			Version: c.LaunchTemplate.Version,
+		}
+	}
+
+	if c.Spot != nil {
+		l.Spot = &myec2.SpotOptions{
+			MaxPrice:             c.Spot.MaxPrice,
+			InterruptionBehavior: c.Spot.InterruptionBehavior,
+		}
 	}
 
 	return l
 }
 
+func (c *EC2RunConfig) ec2Tags() []types.Tag {
+	tags := make([]types.Tag, 0, len(c.Tags))
+	for _, t := range c.Tags {
+		ec2t := types.Tag{
+			Key:   aws.String(t.Key),
+			Value: aws.String(t.Value),
+		}
+		tags = append(tags, ec2t)
+	}
+
+	return tags
+}
+
+// launch a instance. if spot capacity is not available, retry with spot fallback instance types,
+// and launch on-demand instance at last if onDemandFallback is true.
+func launchInstance(ctx context.Context, cli *ec2.Client, launcher *myec2.Launcher, spot *EC2RunSpot, subnetId string, dryrun, onDemandFallback bool) (*ec2.RunInstancesOutput, error) {
+	if launcher.Market != myec2.MARKET_SPOT {
+		return launcher.Launch(ctx, cli, subnetId, 1, dryrun)
+	}
+
+	iTypes := []string{launcher.InstanceType}
+	if spot != nil {
+		iTypes = append(iTypes, spot.InstanceTypes...)
+	}
+
+	var lastErr error
+	for i, iType := range iTypes {
+		l := *launcher
+		l.InstanceType = iType
+
+		// other instance type needs other client token, because same token with other parameters is error.
+		if l.ClientToken != "" && i > 0 {
+			l.ClientToken = launcher.ClientToken + "-" + iType
+		}
+
+		res, err := l.Launch(ctx, cli, subnetId, 1, dryrun)
+		if err == nil {
+			return res, nil
+		}
+
+		if !myec2.IsSpotCapacityError(err) {
+			return nil, err
+		}
+
+		msg(fmt.Sprintf("spot capacity is not available for %s: %v", iType, err))
+		lastErr = err
+	}
+
+	if !onDemandFallback {
+		return nil, lastErr
+	}
+
+	msg(fmt.Sprintf("fallback to on-demand %s", launcher.InstanceType))
+	l := *launcher
+	l.Market = myec2.MARKET_ON_DEMAND
+	l.Spot = nil
+	if l.ClientToken != "" {
+		l.ClientToken = launcher.ClientToken + "-" + myec2.MARKET_ON_DEMAND
+	}
+
+	return l.Launch(ctx, cli, subnetId, 1, dryrun)
+}
+
 type NameTagReplacement struct {
 	Symbol   string
 	Sequence string
+	Vars     map[string]string
 }
 
 func (r *NameTagReplacement) StringWithTemplate(templateString string) (string, error) {
-	t := template.New("instance name template")
+	t := template.New("instance name template").Option("missingkey=error")
 	t, err := t.Parse(templateString)
 	if err != nil {
 		return "", err
@@ -727,10 +1087,11 @@ type EC2RunOutput struct {
 
 	Symbol   string
 	Sequence string
+	Vars     map[string]string
 }
 
 func (o *EC2RunOutput) StringWithTemplate(templateString string) (string, error) {
-	t := template.New("ec2run output template")
+	t := template.New("ec2run output template").Option("missingkey=error")
 	t, err := t.Parse(templateString)
 	if err != nil {
 		return "", err
@@ -754,15 +1115,15 @@ func StoreSkeletonEC2RunConfigYaml(filePath string) error {
 		AmiId:              "ami-xxxxxxx",
 		IamRoleName:        "your_iam_role_name",
 		PlacementGroupName: "your_exists_placment_group",
-		PublicIpEnabled:    false,
-		Ipv6Enabled:        false,
+		PublicIpEnabled:    aws.Bool(false),
+		Ipv6Enabled:        aws.Bool(false),
 		Type:               "t3.nano",
 		KeyPair:            "your_key_pair_name",
-		EbsOptimized:       false,
+		EbsOptimized:       aws.Bool(false),
 		EbsDevices: []EC2RunEbs{
 			EC2RunEbs{
 				DeviceName:          "/dev/xvda",
-				DeleteOnTermination: false,
+				DeleteOnTermination: aws.Bool(false),
 				Encrypted:           &encrypted,
 				SizeGB:              8,
 				VolumeType:          "gp2",
@@ -776,6 +1137,11 @@ func StoreSkeletonEC2RunConfigYaml(filePath string) error {
 		},
 		SecurityGroupIds: []string{"sg-xxxxxxxx", "sg-yyyyyyyy"},
 		UserData:         "#!/bin/bash\ntouch /var/log/rnzoo_userdata_sample.touch",
+		Market:           myec2.MARKET_ON_DEMAND,
+		MetadataOptions: &EC2RunMetadataOptions{
+			RequireIMDSv2: aws.Bool(true),
+			HopLimit:      1,
+		},
 		Launches: []EC2RunConfigLaunch{
 			{
 				NameTagTemplate: "instance {{.Symbol}} {{.Sequence}}",
@@ -799,186 +1165,362 @@ func doEc2run(c *cli.Context) error {
 		return nil
 	}
 
-	region, err := getRegion(c)
-	if err != nil {
-		return ErrExit("failed get region: %v", err)
-	}
-
 	args := c.Args()
 	if args.Len() < 1 {
 		log.Fatal("required ec2 run config file.")
 	}
 
-	cList := make([]EC2RunConfig, 0, args.Len())
-	for _, confPath := range args.Slice() {
-		configs := make([]EC2RunConfig, 0, 1)
-		err := cstore.LoadFromYamlFile(confPath, &configs)
-		if err != nil {
-			return ErrExit("failed load conf file: %v", err)
-		}
+	if c.Bool(OPT_VALIDATE) {
+		return lintEC2RunConfigFiles(c, args.Slice())
+	}
 
-		cList = append(cList, configs...)
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
 	}
 
-	ctx := c.Context
-	cli, err := myec2.MakeEC2Client(ctx, region)
+	loader, err := NewEC2RunConfigLoader(c, false)
 	if err != nil {
-		return ErrExit("failed ec2 client initialization: %v", err)
+		return ErrExit("%v", err)
+	}
+
+	cList, err := loader.Load(args.Slice())
+	if err != nil {
+		return ErrExit("failed load conf file: %v", err)
 	}
 
 	specifiedName := c.String(OPT_SPECIFY_NAME)
 
+	if templateName := c.String(OPT_EXPORT_TEMPLATE); templateName != "" {
+		cli, err := myec2.MakeEC2Client(c.Context, region)
+		if err != nil {
+			return ErrExit("failed ec2 client initialization: %v", err)
+		}
+
+		return exportLaunchTemplate(c, cli, region, templateName, cList)
+	}
+
+	// validate all configs before launching, because failed config after some launches remains the instances.
+	overrides := NewEC2RunOverrides(c)
+	needsAWS := !c.Bool(OPT_PLAN)
 	for _, conf := range cList {
 		if specifiedName != "" && specifiedName != conf.Name {
 			continue
 		}
 
-		tags := make([]types.Tag, 0, len(conf.Tags))
-		for _, t := range conf.Tags {
-			ec2t := types.Tag{
-				Key:   aws.String(t.Key),
-				Value: aws.String(t.Value),
-			}
-			tags = append(tags, ec2t)
+		if err := conf.Validate(overrides); err != nil {
+			return ErrExit("invalid config %s:\n%v", conf.Name, err)
 		}
 
-		launcher := conf.genLauncher()
+		if conf.needsResolution() {
+			needsAWS = true
+		}
+	}
 
-		// overwrite launch parameter with command options
-		if c.String(OPT_AMI_ID) != "" {
-			launcher.AmiId = c.String(OPT_AMI_ID)
+	ctx := c.Context
+	var cli *ec2.Client
+	if needsAWS {
+		cli, err = myec2.MakeEC2Client(ctx, region)
+		if err != nil {
+			return ErrExit("failed ec2 client initialization: %v", err)
 		}
+	}
 
-		for i, l := range conf.Launches {
-			// name replace check before launch instance
-			// because name template fail, the instance is no Name tag instance.
-			nr := &NameTagReplacement{
-				Symbol:   c.String(OPT_SYMBOL),
-				Sequence: strconv.Itoa(i + 1),
-			}
+	// resolve name:/tag: references before launching, because it is failed if there are zero or multiple matches.
+	for i := range cList {
+		conf := &cList[i]
+		if specifiedName != "" && specifiedName != conf.Name {
+			continue
+		}
 
-			// instance type priority
-			// command option > overwrite config > default config
-			if c.String(OPT_I_TYPE) != "" {
-				launcher.InstanceType = c.String(OPT_I_TYPE)
-			} else {
-				if l.OverWriteType != "" {
-					launcher.InstanceType = l.OverWriteType
-				} else {
-					launcher.InstanceType = conf.Type
-				}
-			}
+		if err := conf.ResolveResources(ctx, cli, region); err != nil {
+			return ErrExit("failed resolve resources of %s:\n%v", conf.Name, err)
+		}
+	}
 
-			replacedNameTag, err := nr.StringWithTemplate(l.NameTagTemplate)
-			if err != nil {
-				return ErrExit("error during replacing name tag template: %v", err)
+	// name replace before launch instances
+	// because name template fail, the instance is no Name tag instance.
+	targets, err := expandEC2RunTargets(c, cList)
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	// resolve AMIs before plan for showing the AMI that will be launched.
+	if err := resolveEC2RunAmis(ctx, cli, region, targets); err != nil {
+		return ErrExit("%v", err)
+	}
+
+	// tag at launch, so the instance that returned by same client token in retry has the tags too.
+	for _, t := range targets {
+		t.Launcher.Tags = t.ec2Tags()
+	}
+
+	if err := setEC2RunClientTokens(c, targets); err != nil {
+		return ErrExit("%v", err)
+	}
+
+	if c.Bool(OPT_PLAN) {
+		return printEC2RunPlan(c, region, targets)
+	}
+
+	// launched instances in this run for --atomic rollback.
+	startedAt := time.Now()
+	launched := make([]string, 0, len(targets))
+	failed := func(format string, args ...interface{}) error {
+		if c.Bool(OPT_ATOMIC) {
+			log.Printf(format, args...)
+			if err := rollbackLaunchedInstances(ctx, cli, launched, c.Bool(OPT_YES)); err != nil {
+				return ErrExit("failed rollback: %v", err)
 			}
-			debug(replacedNameTag)
+		}
+
+		return ErrExit(format, args...)
+	}
+
+	for _, t := range targets {
+		debug(t.Name)
 
-			res, err := launcher.Launch(ctx, cli, l.SubnetId, 1, c.Bool(OPT_DRYRUN))
+		if c.Bool(OPT_ENSURE) {
+			exists, err := myec2.GetInstancesByName(ctx, cli, t.Name)
 			if err != nil {
-				// TODO if dry run error then next.
-				return ErrExit("error during starting instance: %s", err.Error())
+				return failed("failed check existing instances: %v", err)
 			}
-			debug(res)
 
-			nameTag := types.Tag{
-				Key:   aws.String("Name"),
-				Value: aws.String(replacedNameTag),
+			if len(exists) > 0 {
+				ids := make([]string, 0, len(exists))
+				for _, ins := range exists {
+					ids = append(ids, convertNilString(ins.InstanceId))
+				}
+				msg(fmt.Sprintf("skipped %s, already exists: %s", t.Name, strings.Join(ids, ",")))
+				continue
 			}
+		}
 
-			for _, ins := range res.Instances {
-				resources := make([]string, 0, 3)
-				resources = append(resources, *ins.InstanceId)
-
-				retrieveErrs := make([]error, 0, 3)
-				for i = 0; i < 3; i++ {
-					// Why sleep?
-					// RunInstance result does not have BlockDeviceMappings
-					// DescribeInstance that RunInstance same time too.
-					// so sleep a second(or few second?)
-					time.Sleep(500 * time.Millisecond)
-					devMaps, err := myec2.GetBlockDeviceMappings(ctx, cli, *ins.InstanceId)
-
-					if err != nil {
-						retrieveErrs = append(retrieveErrs, err)
-						continue
-					}
+		res, err := launchInstance(ctx, cli, t.Launcher, t.Config.Spot, t.Launch.SubnetId, c.Bool(OPT_DRYRUN), t.OnDemandFallback)
+		if err != nil {
+			// TODO if dry run error then next.
+			return failed("error during starting instance: %s", err.Error())
+		}
+		debug(res)
+
+		newInstances := make([]types.Instance, 0, len(res.Instances))
+		for _, ins := range res.Instances {
+			// instance that returned by same client token is launched in previous run. (a minute margin for clock difference)
+			// it was tagged at launch, and it is not rollbacked.
+			if ins.LaunchTime != nil && ins.LaunchTime.Before(startedAt.Add(-time.Minute)) {
+				if ins.State != nil && (ins.State.Name == types.InstanceStateNameTerminated || ins.State.Name == types.InstanceStateNameShuttingDown) {
+					return failed("%s %s that launched with same client token is %s. change --%s or use --%s for launching new instance.", convertNilString(ins.InstanceId), t.Name, ins.State.Name, OPT_SYMBOL, OPT_WITHOUT_CLIENT_TOKEN)
+				}
+				log.Printf("already launched %s %s at %s (same client token)", convertNilString(ins.InstanceId), t.Name, ins.LaunchTime.Format(time.RFC3339))
+				continue
+			}
+			launched = append(launched, convertNilString(ins.InstanceId))
+			newInstances = append(newInstances, ins)
+		}
 
-					if len(devMaps) == 0 {
-						retrieveErrs = append(retrieveErrs, fmt.Errorf("Not found DeviceMappings for: %s. it probably delaying device mapping.", ins.InstanceId))
+		tags := t.ec2Tags()
 
-						continue
-					}
+		for _, ins := range newInstances {
+			resources := make([]string, 0, 3)
+			resources = append(resources, *ins.InstanceId)
 
-					for _, bdm := range devMaps {
-						resources = append(resources, *bdm.Ebs.VolumeId)
-					}
+			retrieveErrs := make([]error, 0, 3)
+			for i := 0; i < 3; i++ {
+				// Why sleep?
+				// RunInstance result does not have BlockDeviceMappings
+				// DescribeInstance that RunInstance same time too.
+				// so sleep a second(or few second?)
+				time.Sleep(500 * time.Millisecond)
+				devMaps, err := myec2.GetBlockDeviceMappings(ctx, cli, *ins.InstanceId)
 
-					retrieveErrs = []error{}
-					break
+				if err != nil {
+					retrieveErrs = append(retrieveErrs, err)
+					continue
 				}
 
-				if len(retrieveErrs) > 0 {
-					// currently, no handling failed tagging EBS
-				}
+				if len(devMaps) == 0 {
+					retrieveErrs = append(retrieveErrs, fmt.Errorf("Not found DeviceMappings for: %s. it probably delaying device mapping.", convertNilString(ins.InstanceId)))
 
-				tagp := &ec2.CreateTagsInput{
-					Resources: resources,
-					// append returns new slice when over cap
-					Tags: append(tags, nameTag),
+					continue
 				}
 
-				_, err := cli.CreateTags(ctx, tagp)
-				if err != nil {
-					log.Printf("failed tagging so skipped %s: %v\n", ins.InstanceId, err)
+				for _, bdm := range devMaps {
+					resources = append(resources, *bdm.Ebs.VolumeId)
 				}
 
-				output := &EC2RunOutput{
-					InstanceId: convertNilString(ins.InstanceId),
-					Name:       replacedNameTag,
-					PublicIp:   convertNilString(ins.PublicIpAddress),
-					PrivateIp:  convertNilString(ins.PrivateIpAddress),
-					Symbol:     nr.Symbol,
-					Sequence:   nr.Sequence,
-				}
+				retrieveErrs = []error{}
+				break
+			}
 
-				outputTemplate := DEFAULT_OUTPUT_TEMPLATE
-				if l.OutputTemplate != "" {
-					outputTemplate = l.OutputTemplate
-				}
+			if len(retrieveErrs) > 0 {
+				// currently, no handling failed tagging EBS
+			}
 
-				idx := strings.Index(outputTemplate, "{{.PublicIp}}")
-				if idx != -1 {
-					insIds := []string{*ins.InstanceId}
-					descIn := &ec2.DescribeInstancesInput{
-						InstanceIds: insIds,
-					}
-					res, err := cli.DescribeInstances(ctx, descIn)
-					if err != nil {
-						log.Printf("failed desc instance: %s", err)
-						continue
-					}
-					if len(res.Reservations) == 1 {
-						if len(res.Reservations[0].Instances) == 1 {
-							output.PublicIp = convertNilString(res.Reservations[0].Instances[0].PublicIpAddress)
-						}
-					}
-				}
+			tagp := &ec2.CreateTagsInput{
+				Resources: resources,
+				Tags:      tags,
+			}
+
+			_, err := cli.CreateTags(ctx, tagp)
+			if err != nil {
+				log.Printf("failed tagging so skipped %s: %v\n", convertNilString(ins.InstanceId), err)
+			}
+
+			nr := t.Replacement
+			output := &EC2RunOutput{
+				InstanceId: convertNilString(ins.InstanceId),
+				Name:       t.Name,
+				PublicIp:   convertNilString(ins.PublicIpAddress),
+				PrivateIp:  convertNilString(ins.PrivateIpAddress),
+				Symbol:     nr.Symbol,
+				Sequence:   nr.Sequence,
+				Vars:       nr.Vars,
+			}
+
+			outputTemplate := DEFAULT_OUTPUT_TEMPLATE
+			if t.Launch.OutputTemplate != "" {
+				outputTemplate = t.Launch.OutputTemplate
+			}
 
-				oString, err := output.StringWithTemplate(outputTemplate)
+			idx := strings.Index(outputTemplate, "{{.PublicIp}}")
+			if idx != -1 {
+				insIds := []string{*ins.InstanceId}
+				descIn := &ec2.DescribeInstancesInput{
+					InstanceIds: insIds,
+				}
+				res, err := cli.DescribeInstances(ctx, descIn)
 				if err != nil {
-					log.Println(fmt.Sprintf("%s failed replacing output template: %v", ins.InstanceId, err))
+					log.Printf("failed desc instance: %s", err)
+					continue
+				}
+				if len(res.Reservations) == 1 {
+					if len(res.Reservations[0].Instances) == 1 {
+						output.PublicIp = convertNilString(res.Reservations[0].Instances[0].PublicIpAddress)
+					}
 				}
+			}
 
-				fmt.Println(oString)
+			oString, err := output.StringWithTemplate(outputTemplate)
+			if err != nil {
+				log.Println(fmt.Sprintf("%s failed replacing output template: %v", convertNilString(ins.InstanceId), err))
 			}
+
+			fmt.Println(oString)
 		}
 	}
 
 	return nil
 }
 
+// terminate the instances that launched in this run.
+func rollbackLaunchedInstances(ctx context.Context, cli *ec2.Client, ids []string, withoutConfirm bool) error {
+	if len(ids) == 0 {
+		msg("there is no launched instance in this run.")
+		return nil
+	}
+
+	if !withoutConfirm {
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+
+		ans, err := confirm("terminate above instances that launched in this run?", false)
+		if err != nil {
+			return err
+		}
+		if !ans {
+			msg("canceled rollback. above instances remain.")
+			return nil
+		}
+	}
+
+	params := &ec2.TerminateInstancesInput{
+		InstanceIds: ids,
+	}
+
+	resp, err := cli.TerminateInstances(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range resp.TerminatingInstances {
+		id := convertNilString(status.InstanceId)
+		pState := convertNilString((*string)(&status.PreviousState.Name))
+		cState := convertNilString((*string)(&status.CurrentState.Name))
+		log.Printf("rollback terminated %s: %s -> %s", id, pState, cState)
+	}
+
+	return nil
+}
+
+// store the config as new launch template version.
+func exportLaunchTemplate(c *cli.Context, ec2cli *ec2.Client, region, templateName string, cList []EC2RunConfig) error {
+	specifiedName := c.String(OPT_SPECIFY_NAME)
+
+	targets := make([]EC2RunConfig, 0, 1)
+	for _, conf := range cList {
+		if specifiedName != "" && specifiedName != conf.Name {
+			continue
+		}
+		targets = append(targets, conf)
+	}
+
+	if len(targets) != 1 {
+		return ErrExit("export target config must be one, but %d configs. please specify config with --%s", len(targets), OPT_SPECIFY_NAME)
+	}
+	conf := targets[0]
+
+	if err := conf.Validate(NewEC2RunOverrides(c)); err != nil {
+		return ErrExit("invalid config %s:\n%v", conf.Name, err)
+	}
+
+	if err := conf.ResolveResources(c.Context, ec2cli, region); err != nil {
+		return ErrExit("failed resolve resources of %s:\n%v", conf.Name, err)
+	}
+
+	launcher := conf.genLauncher()
+	if c.String(OPT_AMI_ID) != "" {
+		launcher.AmiId = c.String(OPT_AMI_ID)
+	} else if conf.Ami != nil {
+		amiId, err := conf.Ami.Resolve(c.Context, ec2cli, region)
+		if err != nil {
+			return ErrExit("failed resolve AMI of %s: %v", conf.Name, err)
+		}
+		launcher.AmiId = amiId
+	}
+	if c.String(OPT_I_TYPE) != "" {
+		launcher.InstanceType = c.String(OPT_I_TYPE)
+	}
+
+	// launch template is shared with the launches, so render without name and sequence.
+	ur := &UserDataReplacement{Symbol: c.String(OPT_SYMBOL), Vars: conf.Vars}
+	userData, err := conf.renderUserData(ur)
+	if err != nil {
+		return ErrExit("failed render user data: %v", err)
+	}
+	launcher.UserData = userData.Data
+
+	// subnet is stored only when all launches use same subnet.
+	subnetId := ""
+	for i, l := range conf.Launches {
+		if i == 0 {
+			subnetId = l.SubnetId
+		} else if subnetId != l.SubnetId {
+			subnetId = ""
+			break
+		}
+	}
+
+	data := launcher.LaunchTemplateData(subnetId, conf.ec2Tags())
+	description := "exported by rnzoo from " + conf.Name
+	templateId, version, err := myec2.CreateLaunchTemplateVersion(c.Context, ec2cli, templateName, description, data)
+	if err != nil {
+		return ErrExit("failed export launch template: %v", err)
+	}
+
+	return OkExit("exported launch_template_id:%s\tname:%s\tversion:%d", templateId, templateName, version)
+}
+
 func doEc2Terminate(c *cli.Context) error {
 	prepare(c)
 
@@ -1238,6 +1780,14 @@ var commandAttachEIP = cli.Command{
 			Name:  OPT_REUSE,
 			Usage: "if there is EIP that has not associated, associate it. if not, allocate new address.",
 		},
+		&cli.StringFlag{
+			Name:  OPT_POOL,
+			Usage: "use the addresses only in the pool with --reuse. new address is allocated into the pool.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_PURPOSE,
+			Usage: "purpose of new address. (rnzoo:purpose tag)",
+		},
 		&cli.BoolFlag{
 			Name:  OPT_MOVE,
 			Usage: "this option was replaced. please use move-eip subcommand.",
@@ -1249,7 +1799,7 @@ var commandMoveEIP = cli.Command{
 	Name:        "move-eip",
 	Category:    CategoryEIP,
 	Usage:       "reallocate EIP(allow reassociate) to other instance.",
-	Description: "reallocate EIP(allow reassociate) to other instance.",
+	Description: MOVEEIP_DESC,
 	Action:      doMoveEIP,
 	Flags: []cli.Flag{
 		&cli.StringFlag{
@@ -1257,6 +1807,26 @@ var commandMoveEIP = cli.Command{
 			Aliases: []string{"r"},
 			Usage:   EC2LIST_REGION_USAGE,
 		},
+		&cli.StringFlag{
+			Name:  OPT_EIP,
+			Usage: "public IP or allocation id of the EIP that is moved.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_TO,
+			Usage: "instance id or Name tag of the target instance.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_TO_ENI,
+			Usage: "target ENI id of the instance.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_PRIVATE_IP,
+			Usage: "target private IP of the instance.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_SWAP,
+			Usage: "exchange the EIPs between the associated instance and the target instance.",
+		},
 		&cli.BoolFlag{
 			Name:  OPT_WITHOUT_CONFIRM,
 			Usage: "without confirm target before action (default action is do confirming)",
@@ -1268,7 +1838,7 @@ var commandDetachEIP = cli.Command{
 	Name:        "detach-eip",
 	Category:    CategoryEIP,
 	Usage:       "disassociate EIP and release it.",
-	Description: `disassociate EIP and release it.`,
+	Description: DETACHEIP_DESC,
 	Action:      doDetachEIP,
 	Flags: []cli.Flag{
 		&cli.StringFlag{
@@ -1280,6 +1850,14 @@ var commandDetachEIP = cli.Command{
 			Name:  OPT_INSTANCE_ID,
 			Usage: "specify instance id.",
 		},
+		&cli.StringFlag{
+			Name:  OPT_EIP,
+			Usage: "public IP or allocation id of the EIP that is detached.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_ALL,
+			Usage: "detach all EIPs of the instance without selecting.",
+		},
 		&cli.BoolFlag{
 			Name:  OPT_WITHOUT_RELEASE,
 			Usage: "does not release disassociated the address.",
@@ -1300,73 +1878,115 @@ func doMoveEIP(c *cli.Context) error {
 	}
 
 	ctx := c.Context
-	// EIP listing
-	allocIds, err := myec2.ChooseEIP(ctx, region)
-
-	if len(allocIds) == 0 {
-		return ErrExit("error during selecting to EIP: %s", err.Error())
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
 	}
 
-	// to instance
 	h, err := NewRnzooCStoreManager()
 	if err != nil {
-		log.Printf("can not load EC2: %v", err)
+		return ErrExit("can not load EC2: %v", err)
 	}
 
-	ids, err := h.ChooseEC2(region, myec2.EC2_STATE_ANY, true)
+	eips, err := h.LoadEIPList(ctx, cli, region)
 	if err != nil {
-		return ErrExit("error during selecting: %s", err.Error())
+		return ErrExit("failed load EIP: %v", err)
 	}
 
-	// one instance
-	instanceId := ""
-	if len(ids) >= 1 {
-		instanceId = ids[0]
-	} else {
-		return ErrExit("error during selecting to instance: %s", err.Error())
+	eip, err := selectEIP(eips, c.String(OPT_EIP))
+	if err != nil {
+		return ErrExit("error during selecting to EIP: %v", err)
 	}
 
-	// moving
-	cli, err := myec2.MakeEC2Client(ctx, region)
+	target, err := selectTargetInstance(ctx, cli, h, region, c.String(OPT_TO))
 	if err != nil {
-		return ErrExit("failed ec2 client initialization: %v", err)
+		return ErrExit("error during selecting to instance: %v", err)
 	}
+	targetId := convertNilString(target.InstanceId)
 
-	if !c.Bool(OPT_WITHOUT_CONFIRM) {
-		insts, err := myec2.GetInstancesFromId(ctx, cli, instanceId)
-		if err != nil {
-			return ErrExit("failed retrieve instance info for confirm.")
+	eniId, privateIp, err := findNetworkInterface(target, c.String(OPT_TO_ENI), c.String(OPT_PRIVATE_IP))
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	if eip.InstanceId == targetId && (eniId == "" || eip.NetworkInterfaceId == eniId) && (privateIp == "" || eip.PrivateIP == privateIp) {
+		return ErrExit("%s is already associated to %s", eip.PublicIP, targetId)
+	}
+
+	var swapped, replaced *myec2.ChoosableEIP
+	if c.Bool(OPT_SWAP) {
+		if eip.Idle {
+			return ErrExit("swap requires the EIP that is associated: %s", eip.PublicIP)
 		}
 
-		if len(insts) != 1 {
-			return ErrExit("the selected from instance was deleted? please retry.")
+		swapped, err = findInstanceEIP(eips, targetId, eniId, privateIp)
+		if err != nil {
+			return ErrExit("%v", err)
 		}
 
-		name := "[no Name tag instance]"
-		for _, t := range insts[0].Tags {
-			if convertNilString(t.Key) == "Name" {
-				name = convertNilString(t.Value)
-				break
-			}
+		if swapped.AllocationId == eip.AllocationId {
+			return ErrExit("can not swap same EIP: %s", eip.PublicIP)
 		}
 
-		eip := allocIds[0].PublicIP
-		from := allocIds[0].Name
-		to := name
-		fmt.Printf("%s '%s' -> '%s'\n", eip, from, to)
+		// the selected EIP goes to the place of the target's EIP.
+		eniId = swapped.NetworkInterfaceId
+		privateIp = swapped.PrivateIP
+	} else {
+		// the target's EIP is disassociated by reassociation.
+		replaced = findReplacedEIP(eips, target, eniId, privateIp)
+	}
+
+	to := fmt.Sprintf("%s(%s)", targetId, getInstanceTag(target, "Name"))
+	if privateIp != "" {
+		to += " " + privateIp
+	}
+
+	if !c.Bool(OPT_WITHOUT_CONFIRM) {
+		fmt.Printf("%s '%s' -> '%s'\n", eip.PublicIP, eipOwner(eip), to)
+		if swapped != nil {
+			fmt.Printf("%s '%s' -> '%s'\n", swapped.PublicIP, eipOwner(swapped), eipOwner(eip))
+		}
+		if replaced != nil {
+			fmt.Printf("%s '%s' -> 'not associated' (replaced, use --%s for exchanging)\n", replaced.PublicIP, eipOwner(replaced), OPT_SWAP)
+		}
 
 		ans, err := confirm("move above EIP?", false)
+		if err != nil {
+			return ErrExit("failed confirm: %v", err)
+		}
 		if !ans {
 			return ErrExit("canceled move EIP action.")
 		}
 	}
 
-	assocId, err := myec2.AssociateEIP(ctx, cli, allocIds[0].AllocationId, instanceId)
+	if swapped != nil {
+		// this disassociates the selected EIP from there.
+		assocId, err := myec2.AssociateEIPToNetworkInterface(ctx, cli, swapped.AllocationId, eip.NetworkInterfaceId, eip.PrivateIP)
+		if err != nil {
+			return ErrExit("error during swapping EIP %s: %v", swapped.PublicIP, err)
+		}
+
+		log.Printf("associated association_id:%s\tpublic_ip:%s\tfrom:%s\tto:%s", convertNilString(assocId), swapped.PublicIP, eipOwner(swapped), eipOwner(eip))
+	}
+
+	var assocId *string
+	if eniId != "" {
+		assocId, err = myec2.AssociateEIPToNetworkInterface(ctx, cli, eip.AllocationId, eniId, privateIp)
+	} else {
+		assocId, err = myec2.AssociateEIP(ctx, cli, eip.AllocationId, targetId)
+	}
 	if err != nil {
-		return ErrExit("error during moving EIP: %s", err.Error())
+		if swapped != nil {
+			return ErrExit("error during moving EIP %s (%s was already moved, %s is not associated): %v", eip.PublicIP, swapped.PublicIP, eip.PublicIP, err)
+		}
+		return ErrExit("error during moving EIP %s: %v", eip.PublicIP, err)
+	}
+
+	if replaced != nil {
+		log.Printf("disassociated public_ip:%s\tfrom:%s\tit is not associated and charged, attach or release it.", replaced.PublicIP, eipOwner(replaced))
 	}
 
-	return OkExit("associated association_id:%s\tpublic_ip:%s\tinstance_id:%s", convertNilString(assocId), "EIP", instanceId)
+	return OkExit("associated association_id:%s\tpublic_ip:%s\tfrom:%s\tto:%s", convertNilString(assocId), eip.PublicIP, eipOwner(eip), to)
 }
 
 // allocate new EIP and associate.
@@ -1413,10 +2033,12 @@ func doAttachEIP(c *cli.Context) error {
 		return ErrExit("failed ec2 client initialization: %v", err)
 	}
 
+	pool := c.String(OPT_POOL)
+
 	var allocId string
 	var ip string
 	if reuseEIP {
-		address, err := myec2.GetNotAssociateEIP(ctx, cli)
+		address, err := myec2.GetNotAssociateEIP(ctx, cli, pool)
 		if err != nil {
 			return ErrExit("failed no associate address so allocate new address...")
 		}
@@ -1429,7 +2051,15 @@ func doAttachEIP(c *cli.Context) error {
 	}
 
 	if allocId == "" {
-		aid, pip, err := myec2.AllocateEIP(ctx, cli)
+		// Name tag is same as the instance.
+		name := ""
+		insts, err := myec2.GetInstancesFromId(ctx, cli, instanceId)
+		if err == nil && len(insts) == 1 {
+			name = getInstanceTag(insts[0], "Name")
+		}
+
+		tags := makeEIPTags(ctx, region, name, c.String(OPT_PURPOSE), pool)
+		aid, pip, err := myec2.AllocateEIP(ctx, cli, tags)
 		if err != nil {
 			return ErrExit("failed allocation address:%s", err.Error())
 		}
@@ -1480,60 +2110,114 @@ func doDetachEIP(c *cli.Context) error {
 		}
 	}
 
+	if instanceId == "" {
+		return ErrExit("there is no instance id.")
+	}
+
 	ctx := c.Context
 	cli, err := myec2.MakeEC2Client(ctx, region)
 	if err != nil {
 		return ErrExit("failed ec2 client initialization: %v", err)
 	}
 
-	address, err := myec2.GetEIPFromInstance(ctx, cli, instanceId)
+	insts, err := myec2.GetInstancesFromId(ctx, cli, instanceId)
+	if err != nil {
+		return ErrExit("failed retrieve instance info: %v", err)
+	}
+
+	if len(insts) != 1 {
+		return ErrExit("the selected from instance was deleted? please retry.")
+	}
+
+	name := getInstanceTag(insts[0], "Name")
+
+	addresses, err := myec2.GetEIPsFromInstance(ctx, cli, insts[0])
 	if err != nil {
 		return ErrExit("failed get EIP from instance: %v", err)
 	}
 
-	if !c.Bool(OPT_WITHOUT_CONFIRM) {
-		insts, err := myec2.GetInstancesFromId(ctx, cli, instanceId)
+	if len(addresses) == 0 {
+		return ErrExit("this instance has not EIP: %s", instanceId)
+	}
+
+	names := map[string]string{instanceId: name}
+	eips := make([]*myec2.ChoosableEIP, 0, len(addresses))
+	for _, addr := range addresses {
+		eips = append(eips, myec2.ConvertChoosableEIP(addr, names))
+	}
+
+	targets := eips
+	switch {
+	case c.String(OPT_EIP) != "":
+		eip, err := selectEIP(eips, c.String(OPT_EIP))
 		if err != nil {
-			return ErrExit("failed retrieve instance info for confirm.")
+			return ErrExit("%v", err)
+		}
+		targets = []*myec2.ChoosableEIP{eip}
+	case c.Bool(OPT_ALL) || len(eips) == 1:
+	default:
+		targets, err = myec2.ChooseEIP(eips)
+		if err != nil {
+			return ErrExit("error during selecting to EIP: %v", err)
 		}
 
-		if len(insts) != 1 {
-			return ErrExit("the selected from instance was deleted? please retry.")
+		if len(targets) == 0 {
+			return ErrExit("there is no selected EIP.")
 		}
+	}
 
-		name := "[no Name tag instance]"
-		for _, t := range insts[0].Tags {
-			if convertNilString(t.Key) == "Name" {
-				name = convertNilString(t.Value)
-				break
-			}
+	if !c.Bool(OPT_WITHOUT_CONFIRM) {
+		if name == "" {
+			name = "[no Name tag instance]"
 		}
 
-		fmt.Printf("%s\t%s\n", name, convertNilString(address.PublicIp))
+		for _, e := range targets {
+			release := "release"
+			if withoutRelease || e.IsReserved() {
+				release = "keep"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", name, e.PublicIP, e.NetworkInterfaceId, e.PrivateIP, release)
+		}
 
 		ans, err := confirm("you really want to detach above EIP?", false)
+		if err != nil {
+			return ErrExit("failed confirm: %v", err)
+		}
 		if !ans {
 			return ErrExit("canceled detach EIP action.")
 		}
 	}
 
-	associationId := convertNilString(address.AssociationId)
-	ip := convertNilString(address.PublicIp)
-	iid := convertNilString(address.InstanceId)
+	failed := 0
+	for _, e := range targets {
+		err := myec2.DisassociateEIP(ctx, cli, e.AssociateId)
+		if err != nil {
+			log.Printf("failed disassociate public_ip:%s\t%v", e.PublicIP, err)
+			failed++
+			continue
+		}
 
-	err = myec2.DisassociateEIP(ctx, cli, convertNilString(address.AssociationId))
-	if err != nil {
-		return ErrExit("failed disassociate address:%s", err.Error())
-	}
+		log.Printf("disassociated assciation_id:%s\tpublic_ip:%s\tinstance_id:%s\tnetwork_interface_id:%s", e.AssociateId, e.PublicIP, e.InstanceId, e.NetworkInterfaceId)
 
-	log.Printf("disassociated assciation_id:%s\tpublic_ip:%s\tinstance_id:%s", associationId, ip, iid)
+		// reserved and pool addresses are kept for reuse.
+		if e.IsReserved() {
+			log.Printf("not released reserved address allocation_id:%s\tpublic_ip:%s\tpool:%s", e.AllocationId, e.PublicIP, e.Pool())
+			continue
+		}
 
-	if !withoutRelease {
-		err := myec2.ReleaseEIP(ctx, cli, convertNilString(address.AllocationId))
-		if err != nil {
-			return ErrExit("failed release address:%s", err.Error())
+		if !withoutRelease {
+			err := myec2.ReleaseEIP(ctx, cli, e.AllocationId)
+			if err != nil {
+				log.Printf("failed release allocation_id:%s\tpublic_ip:%s\t%v", e.AllocationId, e.PublicIP, err)
+				failed++
+				continue
+			}
+			log.Printf("released allocation_id:%s\tpublic_ip:%s", e.AllocationId, e.PublicIP)
 		}
-		log.Printf("released allocation_id:%s\tpublic_ip:%s", convertNilString(address.AllocationId), ip)
+	}
+
+	if failed > 0 {
+		return ErrExit("failed detach %d of %d EIPs.", failed, len(targets))
 	}
 
 	return nil
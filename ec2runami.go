@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	AMI_SSM_PREFIX = "ssm:"
+
+	// tags that record how the AMI was resolved.
+	TAG_AMI_SOURCE = "rnzoo:ami-source"
+	TAG_AMI_ID     = "rnzoo:ami-id"
+)
+
+// AMI that resolved at launch time.
+//
+//	ami: ssm:/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64
+//	ami:
+//	  name: our-base-*
+//	  owner: self
+//	  latest: true
+type EC2RunAmi struct {
+	Name   string `yaml:"name,omitempty"`
+	Owner  string `yaml:"owner,omitempty"`
+	Latest bool   `yaml:"latest,omitempty"`
+	Ssm    string `yaml:"ssm,omitempty"`
+}
+
+// accepts "ssm:<parameter name>" string or mapping.
+func (a *EC2RunAmi) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		if !strings.HasPrefix(s, AMI_SSM_PREFIX) {
+			return fmt.Errorf("ami string must be %s<parameter name>: %s", AMI_SSM_PREFIX, s)
+		}
+		a.Ssm = strings.TrimPrefix(s, AMI_SSM_PREFIX)
+		return nil
+	}
+
+	type rawAmi EC2RunAmi
+	raw := rawAmi{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*a = EC2RunAmi(raw)
+
+	return nil
+}
+
+func (a *EC2RunAmi) validate() error {
+	if a.Ssm == "" && a.Name == "" {
+		return errors.New("ami requires name or ssm parameter")
+	}
+
+	if a.Ssm != "" && (a.Name != "" || a.Owner != "" || a.Latest) {
+		return errors.New("ami can not set both ssm and name/owner/latest")
+	}
+
+	return nil
+}
+
+func (a *EC2RunAmi) String() string {
+	if a.Ssm != "" {
+		return AMI_SSM_PREFIX + a.Ssm
+	}
+
+	s := "name:" + a.Name
+	if a.Owner != "" {
+		s += " owner:" + a.Owner
+	}
+	if a.Latest {
+		s += " latest"
+	}
+
+	return s
+}
+
+// resolve AMI id from SSM parameter or image name.
+func (a *EC2RunAmi) Resolve(ctx context.Context, ec2cli *ec2.Client, region string) (string, error) {
+	if a.Ssm != "" {
+		amiId, err := GetSSMParameter(ctx, region, a.Ssm)
+		if err != nil {
+			return "", fmt.Errorf("failed get SSM parameter %s: %v", a.Ssm, err)
+		}
+
+		if !amiIdPattern.MatchString(amiId) {
+			return "", fmt.Errorf("SSM parameter %s is not AMI id: %s", a.Ssm, amiId)
+		}
+
+		return amiId, nil
+	}
+
+	owners := make([]string, 0, 1)
+	if a.Owner != "" {
+		owners = append(owners, a.Owner)
+	}
+
+	image, err := myec2.ResolveImageByName(ctx, ec2cli, a.Name, a.Latest, owners...)
+	if err != nil {
+		return "", err
+	}
+
+	return convertNilString(image.ImageId), nil
+}
+
+// resolve AMIs of the targets that use ami instead of ami_id.
+// --ami-id option is prior to the config, so it does not resolve.
+func resolveEC2RunAmis(ctx context.Context, ec2cli *ec2.Client, region string, targets []*EC2RunTarget) error {
+	resolved := make(map[*EC2RunConfig]string)
+	for _, t := range targets {
+		if t.AmiSource == "" {
+			continue
+		}
+
+		amiId, ok := resolved[t.Config]
+		if !ok {
+			var err error
+			amiId, err = t.Config.Ami.Resolve(ctx, ec2cli, region)
+			if err != nil {
+				return fmt.Errorf("failed resolve AMI of %s: %v", t.Config.Name, err)
+			}
+
+			debug(fmt.Sprintf("resolved AMI %s: %s", t.AmiSource, amiId))
+			resolved[t.Config] = amiId
+		}
+
+		t.Launcher.AmiId = amiId
+	}
+
+	return nil
+}
+
+func (t *EC2RunTarget) ec2Tags() []types.Tag {
+	tags := t.Config.ec2Tags()
+	if t.AmiSource != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TAG_AMI_SOURCE),
+			Value: aws.String(t.AmiSource),
+		}, types.Tag{
+			Key:   aws.String(TAG_AMI_ID),
+			Value: aws.String(t.Launcher.AmiId),
+		})
+	}
+
+	return append(tags, types.Tag{
+		Key:   aws.String("Name"),
+		Value: aws.String(t.Name),
+	})
+}
@@ -0,0 +1,841 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	LINT_DESC = `
+	validate ec2run configuration yaml files without launching instances.
+
+	it rejects unknown keys, checks resource id formats and parses all templates.
+	with --check-aws option, it also checks that the AMI, security groups, subnets,
+	key pair, IAM instance profile and placement group exist.
+
+	    rnzoo lint --check-aws config.yml
+
+	same as 'rnzoo run --validate config.yml'
+	`
+)
+
+var (
+	amiIdPattern    = regexp.MustCompile(`^ami-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	sgIdPattern     = regexp.MustCompile(`^sg-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	subnetIdPattern = regexp.MustCompile(`^subnet-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	snapIdPattern   = regexp.MustCompile(`^snap-([0-9a-f]{8}|[0-9a-f]{17})$`)
+)
+
+// EBS volume limits per volume type.
+type ebsVolumeLimit struct {
+	MinSizeGB int64
+	MaxSizeGB int64
+	// 0 means iops is not configurable.
+	MinIops int32
+	MaxIops int32
+	// max iops per GiB. 0 means no ratio limit.
+	MaxIopsPerGB int32
+	IopsRequired bool
+	// 0 means throughput is not configurable.
+	MinThroughput int32
+	MaxThroughput int32
+}
+
+var ebsVolumeLimits = map[types.VolumeType]ebsVolumeLimit{
+	types.VolumeTypeStandard: {MinSizeGB: 1, MaxSizeGB: 1024},
+	types.VolumeTypeGp2:      {MinSizeGB: 1, MaxSizeGB: 16384},
+	types.VolumeTypeGp3:      {MinSizeGB: 1, MaxSizeGB: 16384, MinIops: 3000, MaxIops: 16000, MaxIopsPerGB: 500, MinThroughput: 125, MaxThroughput: 1000},
+	types.VolumeTypeIo1:      {MinSizeGB: 4, MaxSizeGB: 16384, MinIops: 100, MaxIops: 64000, MaxIopsPerGB: 50, IopsRequired: true},
+	types.VolumeTypeIo2:      {MinSizeGB: 4, MaxSizeGB: 65536, MinIops: 100, MaxIops: 256000, MaxIopsPerGB: 1000, IopsRequired: true},
+	types.VolumeTypeSt1:      {MinSizeGB: 125, MaxSizeGB: 16384},
+	types.VolumeTypeSc1:      {MinSizeGB: 125, MaxSizeGB: 16384},
+}
+
+var commandLint = cli.Command{
+	Name:        "lint",
+	Category:    CategoryEC2,
+	Usage:       "validate ec2run config yaml files",
+	Description: LINT_DESC,
+	Action:      doLint,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    OPT_REGION,
+			Aliases: []string{"r"},
+			Usage:   EC2LIST_REGION_USAGE,
+		},
+		&cli.StringFlag{
+			Name:  OPT_SPECIFY_NAME,
+			Usage: "specify config name in yaml",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_CHECK_AWS,
+			Usage: "check the resources in the config exist on AWS.",
+		},
+		&cli.StringSliceFlag{
+			Name:  OPT_VAR,
+			Usage: "overwrite config vars. key=value",
+		},
+		&cli.StringFlag{
+			Name:  OPT_ENV,
+			Usage: "merge environment overlay <env>.yml that in same directory of the config.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_SYMBOL,
+			Usage: "replace {{.Symbol}} in config",
+		},
+	},
+}
+
+func doLint(c *cli.Context) error {
+	prepare(c)
+
+	args := c.Args()
+	if args.Len() < 1 {
+		return ErrExit("required ec2 run config file.")
+	}
+
+	return lintEC2RunConfigFiles(c, args.Slice())
+}
+
+// these keys are rendered at launch time, so skip in load time rendering.
+var launchTimeTemplateKeys = map[string]bool{
+	"vars":              true,
+	"name_tag_template": true,
+	"output_template":   true,
+	"user_data":         true,
+	"user_data_parts":   true,
+}
+
+type EC2RunConfigLoader struct {
+	// strict mode rejects unknown keys.
+	Strict bool
+	// environment overlay name. merge <env>.yml in same directory of the config file.
+	Env string
+	// overwrite vars in configs.
+	Vars   map[string]string
+	Symbol string
+}
+
+func NewEC2RunConfigLoader(c *cli.Context, strict bool) (*EC2RunConfigLoader, error) {
+	vars := make(map[string]string)
+	for _, kv := range c.StringSlice(OPT_VAR) {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("invalid --%s format: %s (required key=value)", OPT_VAR, kv)
+		}
+		vars[pair[0]] = pair[1]
+	}
+
+	l := &EC2RunConfigLoader{
+		Strict: strict,
+		Env:    c.String(OPT_ENV),
+		Vars:   vars,
+		Symbol: c.String(OPT_SYMBOL),
+	}
+
+	return l, nil
+}
+
+// vars and symbol for load time rendering.
+type EC2RunConfigReplacement struct {
+	Vars   map[string]string
+	Symbol string
+}
+
+// load ec2run config yaml files.
+// priority of vars: --var option > env overlay > config > include.
+func (l *EC2RunConfigLoader) Load(paths []string) ([]EC2RunConfig, error) {
+	cList := make([]EC2RunConfig, 0, len(paths))
+	for _, confPath := range paths {
+		configs, err := l.loadFile(confPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", confPath, err)
+		}
+
+		cList = append(cList, configs...)
+	}
+
+	return cList, nil
+}
+
+func (l *EC2RunConfigLoader) loadFile(confPath string) ([]EC2RunConfig, error) {
+	raws := make([]map[interface{}]interface{}, 0, 1)
+	if err := loadYamlFile(confPath, &raws); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(confPath)
+
+	var overlay map[interface{}]interface{}
+	if l.Env != "" {
+		overlayPath := filepath.Join(dir, l.Env+filepath.Ext(confPath))
+		if err := loadYamlFile(overlayPath, &overlay); err != nil {
+			return nil, fmt.Errorf("failed load env overlay: %v", err)
+		}
+
+		var err error
+		overlay, err = resolveIncludes(overlay, filepath.Dir(overlayPath), map[string]bool{overlayPath: true})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configs := make([]EC2RunConfig, 0, len(raws))
+	for _, raw := range raws {
+		merged, err := resolveIncludes(raw, dir, map[string]bool{confPath: true})
+		if err != nil {
+			return nil, err
+		}
+
+		if overlay != nil {
+			merged = mergeYamlMap(merged, overlay)
+		}
+
+		vars := make(map[string]string)
+		if v, ok := merged["vars"].(map[interface{}]interface{}); ok {
+			for key, value := range v {
+				vars[fmt.Sprint(key)] = fmt.Sprint(value)
+			}
+		}
+		for key, value := range l.Vars {
+			vars[key] = value
+		}
+		merged["vars"] = vars
+
+		r := &EC2RunConfigReplacement{Vars: vars, Symbol: l.Symbol}
+		rendered, err := renderYamlValue(merged, r)
+		if err != nil {
+			return nil, err
+		}
+
+		// re-marshal for decoding to config struct.
+		yml, err := yaml.Marshal(rendered)
+		if err != nil {
+			return nil, err
+		}
+
+		conf := EC2RunConfig{}
+		if l.Strict {
+			err = yaml.UnmarshalStrict(yml, &conf)
+		} else {
+			err = yaml.Unmarshal(yml, &conf)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// user data files are relative path from the config file.
+		conf.UserDataFile = resolveConfigPath(dir, conf.UserDataFile)
+		for i := range conf.UserDataParts {
+			conf.UserDataParts[i].File = resolveConfigPath(dir, conf.UserDataParts[i].File)
+		}
+
+		configs = append(configs, conf)
+	}
+
+	return configs, nil
+}
+
+func resolveConfigPath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+func loadYamlFile(filePath string, p interface{}) error {
+	yml, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(yml, p)
+}
+
+// merge include fragments under the config. the config values take priority.
+func resolveIncludes(m map[interface{}]interface{}, dir string, visited map[string]bool) (map[interface{}]interface{}, error) {
+	includes, ok := m["include"]
+	if !ok {
+		return m, nil
+	}
+	delete(m, "include")
+
+	paths, ok := includes.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("include must be list of file paths")
+	}
+
+	merged := make(map[interface{}]interface{})
+	for _, p := range paths {
+		incPath := fmt.Sprint(p)
+		if !filepath.IsAbs(incPath) {
+			incPath = filepath.Join(dir, incPath)
+		}
+
+		if visited[incPath] {
+			return nil, fmt.Errorf("include cycle detected: %s", incPath)
+		}
+
+		var fragment map[interface{}]interface{}
+		if err := loadYamlFile(incPath, &fragment); err != nil {
+			return nil, fmt.Errorf("failed load include: %v", err)
+		}
+
+		visited[incPath] = true
+		fragment, err := resolveIncludes(fragment, filepath.Dir(incPath), visited)
+		delete(visited, incPath)
+		if err != nil {
+			return nil, err
+		}
+
+		merged = mergeYamlMap(merged, fragment)
+	}
+
+	return mergeYamlMap(merged, m), nil
+}
+
+// deep merge yaml mappings. overlay values overwrite base, but mappings are merged recursively.
+func mergeYamlMap(base, overlay map[interface{}]interface{}) map[interface{}]interface{} {
+	merged := make(map[interface{}]interface{}, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+
+	for k, v := range overlay {
+		bm, bOk := merged[k].(map[interface{}]interface{})
+		om, oOk := v.(map[interface{}]interface{})
+		if bOk && oOk {
+			merged[k] = mergeYamlMap(bm, om)
+		} else {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
+
+func renderYamlValue(v interface{}, r *EC2RunConfigReplacement) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		return r.StringWithTemplate(t)
+	case []interface{}:
+		rendered := make([]interface{}, 0, len(t))
+		for _, e := range t {
+			re, err := renderYamlValue(e, r)
+			if err != nil {
+				return nil, err
+			}
+			rendered = append(rendered, re)
+		}
+		return rendered, nil
+	case map[interface{}]interface{}:
+		rendered := make(map[interface{}]interface{}, len(t))
+		for k, e := range t {
+			if launchTimeTemplateKeys[fmt.Sprint(k)] {
+				rendered[k] = e
+				continue
+			}
+
+			re, err := renderYamlValue(e, r)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", k, err)
+			}
+			rendered[k] = re
+		}
+		return rendered, nil
+	default:
+		return v, nil
+	}
+}
+
+func (r *EC2RunConfigReplacement) StringWithTemplate(templateString string) (string, error) {
+	if !strings.Contains(templateString, "{{") {
+		return templateString, nil
+	}
+
+	t := template.New("config template").Option("missingkey=error")
+	t, err := t.Parse(templateString)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	err = t.Execute(buf, r)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func lintEC2RunConfigFiles(c *cli.Context, paths []string) error {
+	loader, err := NewEC2RunConfigLoader(c, true)
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	cList, err := loader.Load(paths)
+	if err != nil {
+		return ErrExit("invalid config file: %v", err)
+	}
+
+	var ec2cli *ec2.Client
+	region := ""
+	if c.Bool(OPT_CHECK_AWS) {
+		region, err = getRegion(c)
+		if err != nil {
+			return ErrExit("failed get region: %v", err)
+		}
+
+		ec2cli, err = myec2.MakeEC2Client(c.Context, region)
+		if err != nil {
+			return ErrExit("failed ec2 client initialization: %v", err)
+		}
+	}
+
+	specifiedName := c.String(OPT_SPECIFY_NAME)
+	invalidCount := 0
+	for _, conf := range cList {
+		if specifiedName != "" && specifiedName != conf.Name {
+			continue
+		}
+
+		err := conf.Validate(EC2RunOverrides{})
+		if err == nil && ec2cli != nil {
+			err = conf.CheckAWSResources(c.Context, ec2cli, region)
+		}
+
+		if err != nil {
+			invalidCount++
+			fmt.Printf("NG\t%s\n%v\n", conf.Name, err)
+		} else {
+			fmt.Printf("OK\t%s\n", conf.Name)
+		}
+	}
+
+	if invalidCount > 0 {
+		return ErrExit("%d invalid configs.", invalidCount)
+	}
+
+	return nil
+}
+
+// validate the config without AWS access.
+// command options that overwrite the config values.
+type EC2RunOverrides struct {
+	AmiId        string
+	InstanceType string
+}
+
+func NewEC2RunOverrides(c *cli.Context) EC2RunOverrides {
+	return EC2RunOverrides{
+		AmiId:        c.String(OPT_AMI_ID),
+		InstanceType: c.String(OPT_I_TYPE),
+	}
+}
+
+// validate the config. required values can be given by the overrides.
+func (c *EC2RunConfig) Validate(o EC2RunOverrides) error {
+	errs := make([]error, 0)
+
+	if err := c.validateMarket(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validateLaunchTemplate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	useTemplate := c.LaunchTemplate != nil
+
+	if o.AmiId != "" && !amiIdPattern.MatchString(o.AmiId) {
+		errs = append(errs, fmt.Errorf("invalid --%s format: %s", OPT_AMI_ID, o.AmiId))
+	}
+
+	if c.Ami != nil {
+		if c.AmiId != "" {
+			errs = append(errs, errors.New("can not set both ami_id and ami"))
+		}
+		if err := c.Ami.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	} else if c.AmiId == "" {
+		if !useTemplate && o.AmiId == "" {
+			errs = append(errs, errors.New("ami_id or ami is required"))
+		}
+	} else if !amiIdPattern.MatchString(c.AmiId) {
+		errs = append(errs, fmt.Errorf("invalid ami_id format: %s", c.AmiId))
+	}
+
+	for _, sgId := range c.SecurityGroupIds {
+		if err := validateIdOrRef(sgIdPattern, sgId); err != nil {
+			errs = append(errs, fmt.Errorf("invalid security_group_ids: %v", err))
+		}
+	}
+
+	if myec2.IsResourceRef(c.IamRoleName) {
+		if _, err := myec2.ParseResourceRef(c.IamRoleName); err != nil {
+			errs = append(errs, fmt.Errorf("invalid iam_role_name: %v", err))
+		}
+	}
+
+	if err := c.validateLaunchSettings(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i, e := range c.EbsDevices {
+		if err := e.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("ebs_volumes[%d]: %v", i, err))
+		}
+	}
+
+	for i, p := range c.UserDataParts {
+		if err := p.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("user_data_parts[%d]: %v", i, err))
+		}
+	}
+
+	// render user data with sample values for detecting template errors and size over.
+	ur := &UserDataReplacement{Name: "name", Symbol: "symbol", Sequence: "1", Vars: c.Vars}
+	if _, err := c.renderUserData(ur); err != nil {
+		errs = append(errs, fmt.Errorf("invalid user data: %v", err))
+	}
+
+	for i, t := range c.Tags {
+		if t.Key == "" {
+			errs = append(errs, fmt.Errorf("tags[%d]: key is required", i))
+		}
+	}
+
+	if len(c.Launches) == 0 {
+		errs = append(errs, errors.New("launches is empty"))
+	}
+
+	for i, l := range c.Launches {
+		if c.Type == "" && l.OverWriteType == "" && o.InstanceType == "" && !useTemplate {
+			errs = append(errs, fmt.Errorf("launches[%d]: instance_type is required", i))
+		}
+
+		if l.SubnetId == "" {
+			if !useTemplate {
+				errs = append(errs, fmt.Errorf("launches[%d]: subnet_id is required", i))
+			}
+		} else if err := validateIdOrRef(subnetIdPattern, l.SubnetId); err != nil {
+			errs = append(errs, fmt.Errorf("launches[%d]: invalid subnet_id: %v", i, err))
+		}
+
+		// execute templates with sample values for detecting unknown fields.
+		nr := &NameTagReplacement{Symbol: "symbol", Sequence: "1", Vars: c.Vars}
+		if _, err := nr.StringWithTemplate(l.NameTagTemplate); err != nil {
+			errs = append(errs, fmt.Errorf("launches[%d]: invalid name_tag_template: %v", i, err))
+		}
+
+		if l.OutputTemplate != "" {
+			o := &EC2RunOutput{Symbol: "symbol", Sequence: "1", Vars: c.Vars}
+			if _, err := o.StringWithTemplate(l.OutputTemplate); err != nil {
+				errs = append(errs, fmt.Errorf("launches[%d]: invalid output_template: %v", i, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate allowed combinations of the EBS volume options per volume type.
+func (e *EC2RunEbs) validate() error {
+	if e.DeviceName == "" {
+		return errors.New("device_name is required")
+	}
+
+	if e.NoDevice {
+		if e.SizeGB != 0 || e.VolumeType != "" || e.Iops != 0 || e.Throughput != 0 || e.SnapshotId != "" || e.KmsKeyId != "" || e.Encrypted != nil {
+			return errors.New("no_device can not use with other volume options")
+		}
+		return nil
+	}
+
+	if e.SnapshotId != "" && !snapIdPattern.MatchString(e.SnapshotId) {
+		return fmt.Errorf("invalid snapshot_id format: %s", e.SnapshotId)
+	}
+
+	if e.KmsKeyId != "" && (e.Encrypted == nil || !*e.Encrypted) {
+		return errors.New("kms_key_id requires encrypted: true")
+	}
+
+	// empty volume type is default type (gp2 or gp3) of the AWS account. validate only sizes.
+	volumeType := types.VolumeType(e.VolumeType)
+	if volumeType == "" {
+		if e.Iops != 0 || e.Throughput != 0 {
+			return errors.New("iops and throughput require volume_type")
+		}
+		return nil
+	}
+
+	limit, ok := ebsVolumeLimits[volumeType]
+	if !ok {
+		return fmt.Errorf("unknown volume_type: %s", e.VolumeType)
+	}
+
+	if e.SizeGB != 0 && (e.SizeGB < limit.MinSizeGB || e.SizeGB > limit.MaxSizeGB) {
+		return fmt.Errorf("%s size_gb must be %d-%d: %d", volumeType, limit.MinSizeGB, limit.MaxSizeGB, e.SizeGB)
+	}
+
+	if e.Iops != 0 {
+		if limit.MaxIops == 0 {
+			return fmt.Errorf("%s does not support iops", volumeType)
+		}
+
+		if e.Iops < limit.MinIops || e.Iops > limit.MaxIops {
+			return fmt.Errorf("%s iops must be %d-%d: %d", volumeType, limit.MinIops, limit.MaxIops, e.Iops)
+		}
+
+		if e.SizeGB != 0 && int64(e.Iops) > e.SizeGB*int64(limit.MaxIopsPerGB) {
+			return fmt.Errorf("%s iops must be up to %d per GiB: %d iops for %d GiB", volumeType, limit.MaxIopsPerGB, e.Iops, e.SizeGB)
+		}
+	} else if limit.IopsRequired {
+		return fmt.Errorf("%s requires iops", volumeType)
+	}
+
+	if e.Throughput != 0 {
+		if limit.MaxThroughput == 0 {
+			return fmt.Errorf("%s does not support throughput", volumeType)
+		}
+
+		if e.Throughput < limit.MinThroughput || e.Throughput > limit.MaxThroughput {
+			return fmt.Errorf("%s throughput must be %d-%d MiB/s: %d", volumeType, limit.MinThroughput, limit.MaxThroughput, e.Throughput)
+		}
+	}
+
+	return nil
+}
+
+func (c *EC2RunConfig) validateLaunchSettings() error {
+	errs := make([]error, 0)
+
+	if m := c.MetadataOptions; m != nil && (m.HopLimit < 0 || m.HopLimit > 64) {
+		errs = append(errs, fmt.Errorf("metadata_options.hop_limit must be 1-64: %d", m.HopLimit))
+	}
+
+	switch types.ShutdownBehavior(c.ShutdownBehavior) {
+	case "", types.ShutdownBehaviorStop, types.ShutdownBehaviorTerminate:
+	default:
+		errs = append(errs, fmt.Errorf("unknown shutdown_behavior: %s (allowed stop or terminate)", c.ShutdownBehavior))
+	}
+
+	switch c.CpuCredits {
+	case "", "standard", "unlimited":
+	default:
+		errs = append(errs, fmt.Errorf("unknown cpu_credits: %s (allowed standard or unlimited)", c.CpuCredits))
+	}
+
+	if p := c.Placement; p != nil {
+		switch types.Tenancy(p.Tenancy) {
+		case "", types.TenancyDefault, types.TenancyDedicated, types.TenancyHost:
+		default:
+			errs = append(errs, fmt.Errorf("unknown placement.tenancy: %s (allowed default, dedicated or host)", p.Tenancy))
+		}
+
+		if p.HostId != "" && types.Tenancy(p.Tenancy) != types.TenancyHost {
+			errs = append(errs, errors.New("placement.host_id requires tenancy: host"))
+		}
+	}
+
+	if len(c.SecondaryInterfaces) > 0 && c.PublicIpEnabled != nil && *c.PublicIpEnabled {
+		errs = append(errs, errors.New("public_ip_enabled can not use with secondary_network_interfaces"))
+	}
+
+	for i, ni := range c.SecondaryInterfaces {
+		if ni.SubnetId != "" {
+			if err := validateIdOrRef(subnetIdPattern, ni.SubnetId); err != nil {
+				errs = append(errs, fmt.Errorf("secondary_network_interfaces[%d]: invalid subnet_id: %v", i, err))
+			}
+		}
+
+		for _, sgId := range ni.SecurityGroupIds {
+			if err := validateIdOrRef(sgIdPattern, sgId); err != nil {
+				errs = append(errs, fmt.Errorf("secondary_network_interfaces[%d]: invalid security_group_ids: %v", i, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// id or name:/tag: reference.
+func validateIdOrRef(idPattern *regexp.Regexp, s string) error {
+	if myec2.IsResourceRef(s) {
+		_, err := myec2.ParseResourceRef(s)
+		return err
+	}
+
+	if !idPattern.MatchString(s) {
+		return fmt.Errorf("invalid format: %s", s)
+	}
+
+	return nil
+}
+
+func withoutRefs(ids []string) []string {
+	filtered := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !myec2.IsResourceRef(id) {
+			filtered = append(filtered, id)
+		}
+	}
+
+	return filtered
+}
+
+// the config has references that are resolved with AWS access.
+func (c *EC2RunConfig) needsResolution() bool {
+	if c.Ami != nil || myec2.IsResourceRef(c.IamRoleName) {
+		return true
+	}
+
+	for _, sgId := range c.SecurityGroupIds {
+		if myec2.IsResourceRef(sgId) {
+			return true
+		}
+	}
+
+	for _, l := range c.Launches {
+		if myec2.IsResourceRef(l.SubnetId) {
+			return true
+		}
+	}
+
+	for _, ni := range c.SecondaryInterfaces {
+		if myec2.IsResourceRef(ni.SubnetId) {
+			return true
+		}
+		for _, sgId := range ni.SecurityGroupIds {
+			if myec2.IsResourceRef(sgId) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// resolve name:/tag: references of security groups, subnets and IAM instance profile to ids.
+// the resolved ids overwrite the config. the references that failed resolving remain.
+func (c *EC2RunConfig) ResolveResources(ctx context.Context, ec2cli *ec2.Client, region string) error {
+	errs := make([]error, 0)
+
+	resolveSgIds := func(sgIds []string) {
+		for i, sgId := range sgIds {
+			resolved, err := myec2.ResolveSecurityGroupId(ctx, ec2cli, sgId)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			sgIds[i] = resolved
+		}
+	}
+
+	resolveSubnetId := func(subnetId *string) {
+		resolved, err := myec2.ResolveSubnetId(ctx, ec2cli, *subnetId)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		*subnetId = resolved
+	}
+
+	resolveSgIds(c.SecurityGroupIds)
+	for i := range c.Launches {
+		resolveSubnetId(&c.Launches[i].SubnetId)
+	}
+
+	for i := range c.SecondaryInterfaces {
+		ni := &c.SecondaryInterfaces[i]
+		if ni.SubnetId != "" {
+			resolveSubnetId(&ni.SubnetId)
+		}
+		resolveSgIds(ni.SecurityGroupIds)
+	}
+
+	if c.IamRoleName != "" {
+		resolved, err := ResolveInstanceProfileName(ctx, region, c.IamRoleName)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			c.IamRoleName = resolved
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// check the resources that referred from the config exist.
+func (c *EC2RunConfig) CheckAWSResources(ctx context.Context, ec2cli *ec2.Client, region string) error {
+	errs := make([]error, 0)
+
+	// references are checked by resolving.
+	if err := c.ResolveResources(ctx, ec2cli, region); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.AmiId != "" {
+		if err := myec2.CheckImagesExist(ctx, ec2cli, c.AmiId); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Ami != nil {
+		if _, err := c.Ami.Resolve(ctx, ec2cli, region); err != nil {
+			errs = append(errs, fmt.Errorf("failed resolve ami %s: %v", c.Ami, err))
+		}
+	}
+
+	if err := myec2.CheckSecurityGroupsExist(ctx, ec2cli, withoutRefs(c.SecurityGroupIds)...); err != nil {
+		errs = append(errs, err)
+	}
+
+	subnetIds := make([]string, 0, len(c.Launches))
+	for _, l := range c.Launches {
+		if l.SubnetId != "" && !myec2.IsResourceRef(l.SubnetId) {
+			subnetIds = append(subnetIds, l.SubnetId)
+		}
+	}
+	if err := myec2.CheckSubnetsExist(ctx, ec2cli, subnetIds...); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.KeyPair != "" {
+		if err := myec2.CheckKeyPairExists(ctx, ec2cli, c.KeyPair); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.PlacementGroupName != "" {
+		if err := myec2.CheckPlacementGroupExists(ctx, ec2cli, c.PlacementGroupName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.IamRoleName != "" && !myec2.IsResourceRef(c.IamRoleName) {
+		if err := CheckInstanceProfileExists(ctx, region, c.IamRoleName); err != nil {
+			errs = append(errs, fmt.Errorf("not found IAM instance profile %s: %v", c.IamRoleName, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	PLAN_ACTION_LAUNCH    = "launch"
+	PLAN_ACTION_DUPLICATE = "duplicate"
+	PLAN_ACTION_SKIP      = "skip"
+)
+
+// a instance launch that expanded from the config launches.
+type EC2RunTarget struct {
+	Config           *EC2RunConfig
+	Launch           EC2RunConfigLaunch
+	Launcher         *myec2.Launcher
+	Name             string
+	Replacement      *NameTagReplacement
+	AmiSource        string
+	UserData         *UserData
+	OnDemandFallback bool
+}
+
+// expand configs to launch targets with command options.
+func expandEC2RunTargets(c *cli.Context, cList []EC2RunConfig) ([]*EC2RunTarget, error) {
+	specifiedName := c.String(OPT_SPECIFY_NAME)
+
+	targets := make([]*EC2RunTarget, 0, len(cList))
+	for ci := range cList {
+		conf := &cList[ci]
+		if specifiedName != "" && specifiedName != conf.Name {
+			continue
+		}
+
+		onDemandFallback := c.Bool(OPT_FALLBACK_ON_DEMAND)
+		if conf.Spot != nil && conf.Spot.FallbackOnDemand {
+			onDemandFallback = true
+		}
+
+		for i, l := range conf.Launches {
+			launcher := conf.genLauncher()
+
+			// overwrite launch parameter with command options
+			amiSource := ""
+			if c.String(OPT_AMI_ID) != "" {
+				launcher.AmiId = c.String(OPT_AMI_ID)
+			} else if conf.Ami != nil {
+				// resolved later, because it requires AWS access.
+				amiSource = conf.Ami.String()
+			}
+
+			// instance type priority
+			// command option > overwrite config > default config
+			if c.String(OPT_I_TYPE) != "" {
+				launcher.InstanceType = c.String(OPT_I_TYPE)
+			} else if l.OverWriteType != "" {
+				launcher.InstanceType = l.OverWriteType
+			}
+
+			launcher.PrivateIp = l.PrivateIp
+
+			nr := &NameTagReplacement{
+				Symbol:   c.String(OPT_SYMBOL),
+				Sequence: strconv.Itoa(i + 1),
+				Vars:     conf.Vars,
+			}
+
+			name, err := nr.StringWithTemplate(l.NameTagTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("error during replacing name tag template: %v", err)
+			}
+
+			ur := &UserDataReplacement{
+				Name:     name,
+				Symbol:   nr.Symbol,
+				Sequence: nr.Sequence,
+				Vars:     nr.Vars,
+			}
+
+			userData, err := conf.renderUserData(ur)
+			if err != nil {
+				return nil, fmt.Errorf("error during rendering user data for %s: %v", name, err)
+			}
+			launcher.UserData = userData.Data
+
+			t := &EC2RunTarget{
+				Config:           conf,
+				Launch:           l,
+				Launcher:         launcher,
+				Name:             name,
+				Replacement:      nr,
+				AmiSource:        amiSource,
+				UserData:         userData,
+				OnDemandFallback: onDemandFallback,
+			}
+			targets = append(targets, t)
+		}
+	}
+
+	return targets, nil
+}
+
+// set client tokens after the launch parameters are decided. (AMI is resolved)
+func setEC2RunClientTokens(c *cli.Context, targets []*EC2RunTarget) error {
+	if c.Bool(OPT_WITHOUT_CLIENT_TOKEN) {
+		return nil
+	}
+
+	for _, t := range targets {
+		params, err := json.Marshal(t.Launcher.RunInstancesInput(t.Launch.SubnetId, 1, false))
+		if err != nil {
+			return fmt.Errorf("failed generate client token for %s: %v", t.Name, err)
+		}
+
+		nr := t.Replacement
+		t.Launcher.ClientToken = genClientToken(t.Config.Name, nr.Sequence, nr.Symbol, t.Name, params)
+	}
+
+	return nil
+}
+
+// deterministic client token from config name, launch entry, symbol and the launch parameters.
+// the parameters are included, because same token with different parameters is IdempotentParameterMismatch error.
+// max length of client token is 64 ASCII characters.
+func genClientToken(configName, sequence, symbol, name string, params []byte) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{configName, sequence, symbol, name, string(params)}, "\x00")))
+	return "rnzoo-" + hex.EncodeToString(sum[:])[:32]
+}
+
+type EC2RunPlan struct {
+	Config            string                 `json:"config"`
+	Sequence          string                 `json:"sequence"`
+	Name              string                 `json:"name"`
+	AmiSource         string                 `json:"ami_source,omitempty"`
+	Action            string                 `json:"action"`
+	ExistingInstances []string               `json:"existing_instances"`
+	SpotFallbackTypes []string               `json:"spot_fallback_instance_types,omitempty"`
+	OnDemandFallback  bool                   `json:"on_demand_fallback"`
+	Tags              []types.Tag            `json:"tags"`
+	UserData          string                 `json:"user_data"`
+	UserDataGzip      bool                   `json:"user_data_gzip"`
+	RunInstancesInput *ec2.RunInstancesInput `json:"run_instances_input"`
+}
+
+func makeEC2RunPlans(targets []*EC2RunTarget, existing []*myec2.ChoosableEC2, dryrun, ensure bool) []*EC2RunPlan {
+	nameMap := make(map[string][]string)
+	for _, e := range existing {
+		if e.Name == "" || e.Status == string(types.InstanceStateNameTerminated) || e.Status == string(types.InstanceStateNameShuttingDown) {
+			continue
+		}
+		nameMap[e.Name] = append(nameMap[e.Name], e.InstanceId+"("+e.Status+")")
+	}
+
+	plans := make([]*EC2RunPlan, 0, len(targets))
+	for _, t := range targets {
+		tags := t.ec2Tags()
+
+		p := &EC2RunPlan{
+			Config:            t.Config.Name,
+			Sequence:          t.Replacement.Sequence,
+			Name:              t.Name,
+			AmiSource:         t.AmiSource,
+			Action:            PLAN_ACTION_LAUNCH,
+			ExistingInstances: nameMap[t.Name],
+			OnDemandFallback:  t.Launcher.Market == myec2.MARKET_SPOT && t.OnDemandFallback,
+			Tags:              tags,
+			UserData:          t.UserData.Text,
+			UserDataGzip:      t.UserData.Compressed,
+			RunInstancesInput: t.Launcher.RunInstancesInput(t.Launch.SubnetId, 1, dryrun),
+		}
+
+		if len(p.ExistingInstances) > 0 {
+			if ensure {
+				p.Action = PLAN_ACTION_SKIP
+			} else {
+				p.Action = PLAN_ACTION_DUPLICATE
+			}
+		}
+
+		if t.Launcher.Market == myec2.MARKET_SPOT && t.Config.Spot != nil {
+			p.SpotFallbackTypes = t.Config.Spot.InstanceTypes
+		}
+
+		plans = append(plans, p)
+	}
+
+	return plans
+}
+
+// show the plan that would be launched. it does not access to AWS, AMIs are resolved before.
+func printEC2RunPlan(c *cli.Context, region string, targets []*EC2RunTarget) error {
+	var existing []*myec2.ChoosableEC2
+	h, err := NewRnzooCStoreManager()
+	if err == nil {
+		existing, err = h.LoadCachedChoosableEC2List(region, myec2.EC2_STATE_ANY)
+	}
+	if err != nil {
+		msg(fmt.Sprintf("skip comparing with existing instances: %v", err))
+	}
+
+	plans := makeEC2RunPlans(targets, existing, c.Bool(OPT_DRYRUN), c.Bool(OPT_ENSURE))
+
+	if c.Bool(OPT_JSON) {
+		b, err := json.MarshalIndent(plans, "", "  ")
+		if err != nil {
+			return ErrExit("failed output plan: %v", err)
+		}
+
+		fmt.Println(string(b))
+		return nil
+	}
+
+	launches, duplicates := 0, 0
+	for _, p := range plans {
+		in := p.RunInstancesInput
+		mark := "+"
+		switch p.Action {
+		case PLAN_ACTION_SKIP:
+			fmt.Printf("= %s [%s #%s] already exists: %s\n", p.Name, p.Config, p.Sequence, strings.Join(p.ExistingInstances, ","))
+			continue
+		case PLAN_ACTION_DUPLICATE:
+			mark = "~"
+			duplicates++
+		}
+		launches++
+
+		fmt.Printf("%s %s [%s #%s]\n", mark, p.Name, p.Config, p.Sequence)
+		amiId := convertNilString(in.ImageId)
+		if p.AmiSource != "" {
+			amiId += " (" + p.AmiSource + ")"
+		}
+		printPlanItem("ami_id", amiId)
+		printPlanItem("instance_type", string(in.InstanceType))
+
+		market := myec2.MARKET_ON_DEMAND
+		if in.InstanceMarketOptions != nil {
+			market = string(in.InstanceMarketOptions.MarketType)
+			fallbacks := append([]string{}, p.SpotFallbackTypes...)
+			if p.OnDemandFallback {
+				fallbacks = append(fallbacks, myec2.MARKET_ON_DEMAND)
+			}
+			if len(fallbacks) > 0 {
+				market += " (fallback: " + strings.Join(fallbacks, ", ") + ")"
+			}
+		}
+		printPlanItem("market", market)
+
+		if in.LaunchTemplate != nil {
+			lt := convertNilString(in.LaunchTemplate.LaunchTemplateId) + convertNilString(in.LaunchTemplate.LaunchTemplateName)
+			if v := convertNilString(in.LaunchTemplate.Version); v != "" {
+				lt += " version " + v
+			}
+			printPlanItem("launch_template", lt)
+		}
+
+		for _, ni := range in.NetworkInterfaces {
+			printPlanItem("subnet_id", convertNilString(ni.SubnetId))
+			printPlanItem("security_groups", strings.Join(ni.Groups, ","))
+		}
+
+		tagPairs := make([]string, 0, len(p.Tags))
+		for _, t := range p.Tags {
+			tagPairs = append(tagPairs, convertNilString(t.Key)+"="+convertNilString(t.Value))
+		}
+		printPlanItem("tags", strings.Join(tagPairs, ","))
+
+		if len(p.ExistingInstances) > 0 {
+			printPlanItem("same Name", strings.Join(p.ExistingInstances, ","))
+		}
+
+		if p.UserData != "" {
+			if p.UserDataGzip {
+				fmt.Printf("    user_data: (gzip compressed, %d bytes before compression)\n", len(p.UserData))
+			} else {
+				fmt.Println("    user_data:")
+			}
+			for _, line := range strings.Split(strings.TrimRight(p.UserData, "\n"), "\n") {
+				fmt.Println("        " + line)
+			}
+		}
+	}
+
+	fmt.Printf("\n%d instances will be launched. (%d instances have same Name instance)\n", launches, duplicates)
+	return nil
+}
+
+func printPlanItem(key, value string) {
+	if value == "" {
+		return
+	}
+
+	fmt.Printf("    %-16s %s\n", key+":", value)
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/urfave/cli/v2"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	EIP_DESC = `
+	manage Elastic IPs.
+
+	ls shows all addresses in the region with association, tags and whether it is idle.
+	idle addresses are not associated to any instance/ENI, but are charged.
+
+	    rnzoo eip ls --tsv
+	    rnzoo eip ls --json
+
+	tsv columns: allocation_id, public_ip, association_id, instance_id, Name, ENI, private_ip, network_border_group, tags, idle/in-use
+
+	gc releases the idle addresses that you select (or --all idle addresses).
+	the addresses that have rnzoo:reserved tag (except "false") or are in a pool are excluded.
+	age is known only for the addresses that rnzoo allocated (rnzoo:allocated-at tag).
+
+	IMPORTANT: gc default action is dry run, please set --execute option when do release.
+
+	    rnzoo eip gc --all --execute
+
+	reserve allocates addresses into the pool (rnzoo:pool tag) beforehand.
+	attach-eip --reuse --pool uses only the idle addresses in the pool.
+
+	    rnzoo eip reserve --pool api-egress --count 2
+	    rnzoo attach-eip --reuse --pool api-egress
+
+	rnzoo tags allocated addresses with Name, rnzoo:purpose, rnzoo:allocated-by and rnzoo:allocated-at.
+	`
+)
+
+var commandEIP = cli.Command{
+	Name:        "eip",
+	Category:    CategoryEIP,
+	Usage:       "show and manage Elastic IPs.",
+	Description: EIP_DESC,
+	Subcommands: []*cli.Command{
+		{
+			Name:   "ls",
+			Usage:  "show Elastic IPs with association info.",
+			Action: doEIPList,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.BoolFlag{
+					Name:    OPT_TSV,
+					Aliases: []string{"t"},
+					Usage:   EC2LIST_TSV,
+				},
+				&cli.BoolFlag{
+					Name:  OPT_JSON,
+					Usage: "output JSON format.",
+				},
+			},
+		},
+		{
+			Name:   "gc",
+			Usage:  "release idle Elastic IPs. (default dry run)",
+			Action: doEIPGc,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.BoolFlag{
+					Name:  OPT_ALL,
+					Usage: "release all idle addresses without selecting.",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_DRYRUN,
+					Usage: "dry-run release.",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_EXECUTE,
+					Usage: "execute release (default action is dryrun. if execute and dryrun options set in same time, then do dryrun)",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_WITHOUT_CONFIRM,
+					Usage: "without target addresses confirming (default action is do confirming)",
+				},
+			},
+		},
+		{
+			Name:   "reserve",
+			Usage:  "allocate Elastic IPs into the pool.",
+			Action: doEIPReserve,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:     OPT_POOL,
+					Usage:    "pool name.",
+					Required: true,
+				},
+				&cli.IntFlag{
+					Name:  OPT_COUNT,
+					Value: 1,
+					Usage: "number of the addresses.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_PURPOSE,
+					Usage: "purpose of the addresses. (rnzoo:purpose tag)",
+				},
+			},
+		},
+	},
+}
+
+// tags for allocating address. empty values are not tagged.
+func makeEIPTags(ctx context.Context, region, name, purpose, pool string) []types.Tag {
+	allocatedBy, err := GetCallerArn(ctx, region)
+	if err != nil {
+		debug(fmt.Sprintf("can not get caller identity: %v", err))
+		allocatedBy = os.Getenv("USER")
+	}
+
+	values := [][2]string{
+		{"Name", name},
+		{myec2.TAG_EIP_PURPOSE, purpose},
+		{myec2.TAG_EIP_ALLOCATED_BY, allocatedBy},
+		{myec2.TAG_EIP_POOL, pool},
+	}
+
+	tags := make([]types.Tag, 0, len(values))
+	for _, v := range values {
+		if v[1] == "" {
+			continue
+		}
+		tags = append(tags, types.Tag{Key: aws.String(v[0]), Value: aws.String(v[1])})
+	}
+
+	return tags
+}
+
+func doEIPList(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		return ErrExit("can not load EC2: %v", err)
+	}
+
+	eips, err := h.LoadEIPList(ctx, cli, region)
+	if err != nil {
+		return ErrExit("can not load EIP: %v", err)
+	}
+
+	if c.Bool(OPT_JSON) {
+		b, err := json.MarshalIndent(eips, "", "  ")
+		if err != nil {
+			return ErrExit("failed convert to JSON: %v", err)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	idle := 0
+	idleCost := 0.0
+	for _, e := range eips {
+		if c.Bool(OPT_TSV) {
+			fmt.Println(e)
+		} else {
+			fmt.Println(e.Choice())
+		}
+
+		if e.Idle {
+			idle++
+			idleCost += e.MonthlyCost()
+		}
+	}
+
+	if idle > 0 {
+		log.Printf("%d idle addresses (about $%.2f/month)", idle, idleCost)
+	}
+
+	return nil
+}
+
+func doEIPGc(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		return ErrExit("can not load EC2: %v", err)
+	}
+
+	eips, err := h.LoadEIPList(ctx, cli, region)
+	if err != nil {
+		return ErrExit("can not load EIP: %v", err)
+	}
+
+	idles := make([]*myec2.ChoosableEIP, 0, len(eips))
+	for _, e := range eips {
+		if !e.Idle {
+			continue
+		}
+
+		if e.IsReserved() {
+			debug(fmt.Sprintf("skip reserved address %s %s", e.PublicIP, e.AllocationId))
+			continue
+		}
+
+		idles = append(idles, e)
+	}
+
+	if len(idles) == 0 {
+		return OkExit("there is no idle address.")
+	}
+
+	targets := idles
+	if !c.Bool(OPT_ALL) {
+		targets, err = myec2.ChooseEIP(idles)
+		if err != nil {
+			return ErrExit("error during selecting: %v", err)
+		}
+
+		if len(targets) == 0 {
+			return ErrExit("there is no selected address.")
+		}
+	}
+
+	if !c.Bool(OPT_WITHOUT_CONFIRM) {
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "public_ip\tallocation_id\tage\ttags")
+		for _, e := range targets {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.PublicIP, e.AllocationId, e.Age(now), e.TagString())
+		}
+		w.Flush()
+
+		ans, err := confirm("you really want to release above addresses?", false)
+		if err != nil {
+			return ErrExit("failed confirm: %v", err)
+		}
+		if !ans {
+			return ErrExit("canceled release addresses.")
+		}
+	}
+
+	dryrun := true
+	if !c.Bool(OPT_DRYRUN) && c.Bool(OPT_EXECUTE) {
+		dryrun = false
+	}
+
+	failed := 0
+	for _, e := range targets {
+		if dryrun {
+			log.Printf("dry-run: release %s %s", e.PublicIP, e.AllocationId)
+			continue
+		}
+
+		if err := myec2.ReleaseEIP(ctx, cli, e.AllocationId); err != nil {
+			log.Printf("failed release %s %s: %v", e.PublicIP, e.AllocationId, err)
+			failed++
+			continue
+		}
+
+		log.Printf("released %s %s", e.PublicIP, e.AllocationId)
+	}
+
+	if failed > 0 {
+		return ErrExit("failed release %d of %d addresses.", failed, len(targets))
+	}
+
+	if dryrun {
+		log.Printf("it was dry run. please set --execute option when do release.")
+	}
+
+	return nil
+}
+
+func doEIPReserve(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	count := c.Int(OPT_COUNT)
+	if count < 1 {
+		return ErrExit("count must be 1 or more: %d", count)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	pool := c.String(OPT_POOL)
+	tags := makeEIPTags(ctx, region, pool, c.String(OPT_PURPOSE), pool)
+	for i := 0; i < count; i++ {
+		allocId, ip, err := myec2.AllocateEIP(ctx, cli, tags)
+		if err != nil {
+			return ErrExit("failed allocation address (allocated %d of %d): %v", i, count, err)
+		}
+
+		log.Printf("allocated allocation_id:%s\tpublic_ip:%s\tpool:%s", convertNilString(allocId), convertNilString(ip), pool)
+	}
+
+	return OkExit("reserved %d addresses into pool %s", count, pool)
+}
+
+// select EIP by public IP or allocation id. if it is empty, select with peco.
+func selectEIP(eips []*myec2.ChoosableEIP, s string) (*myec2.ChoosableEIP, error) {
+	if s == "" {
+		chosen, err := myec2.ChooseEIP(eips)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(chosen) == 0 {
+			return nil, fmt.Errorf("there is no selected EIP.")
+		}
+
+		return chosen[0], nil
+	}
+
+	for _, e := range eips {
+		if e.PublicIP == s || e.AllocationId == s {
+			return e, nil
+		}
+	}
+
+	return nil, fmt.Errorf("not found EIP: %s", s)
+}
+
+// select instance by instance id or Name tag. if it is empty, select with peco.
+func selectTargetInstance(ctx context.Context, cli *ec2.Client, h *myec2.EC2Handler, region, s string) (types.Instance, error) {
+	var insts []types.Instance
+	var err error
+	switch {
+	case s == "":
+		ids, chooseErr := h.ChooseEC2(region, myec2.EC2_STATE_ANY, true)
+		if chooseErr != nil {
+			return types.Instance{}, chooseErr
+		}
+
+		if len(ids) == 0 {
+			return types.Instance{}, fmt.Errorf("there is no selected instance.")
+		}
+
+		insts, err = myec2.GetInstancesFromId(ctx, cli, ids[0])
+	case strings.HasPrefix(s, "i-"):
+		insts, err = myec2.GetInstancesFromId(ctx, cli, s)
+	default:
+		insts, err = myec2.GetInstancesByName(ctx, cli, s)
+	}
+	if err != nil {
+		return types.Instance{}, err
+	}
+
+	switch len(insts) {
+	case 0:
+		return types.Instance{}, fmt.Errorf("not found instance: %s", s)
+	case 1:
+		return insts[0], nil
+	default:
+		ids := make([]string, 0, len(insts))
+		for _, ins := range insts {
+			ids = append(ids, convertNilString(ins.InstanceId))
+		}
+		return types.Instance{}, fmt.Errorf("%s matched multiple instances, please specify instance id: %s", s, strings.Join(ids, ","))
+	}
+}
+
+// find ENI and private IP of the instance. both empty returns empty (use the primary).
+func findNetworkInterface(ins types.Instance, eniId, privateIp string) (string, string, error) {
+	if eniId == "" && privateIp == "" {
+		return "", "", nil
+	}
+
+	for _, ni := range ins.NetworkInterfaces {
+		id := convertNilString(ni.NetworkInterfaceId)
+		if eniId != "" && id != eniId {
+			continue
+		}
+
+		if privateIp == "" {
+			return id, convertNilString(ni.PrivateIpAddress), nil
+		}
+
+		for _, addr := range ni.PrivateIpAddresses {
+			if convertNilString(addr.PrivateIpAddress) == privateIp {
+				return id, privateIp, nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("not found ENI %s private IP %s in %s", eniId, privateIp, convertNilString(ins.InstanceId))
+}
+
+// find the EIP that is associated to the instance (and the ENI/private IP if specified).
+func findInstanceEIP(eips []*myec2.ChoosableEIP, instanceId, eniId, privateIp string) (*myec2.ChoosableEIP, error) {
+	matched := make([]*myec2.ChoosableEIP, 0, 1)
+	for _, e := range eips {
+		if e.InstanceId != instanceId {
+			continue
+		}
+		if eniId != "" && e.NetworkInterfaceId != eniId {
+			continue
+		}
+		if privateIp != "" && e.PrivateIP != privateIp {
+			continue
+		}
+
+		matched = append(matched, e)
+	}
+
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("%s has no EIP to swap.", instanceId)
+	case 1:
+		return matched[0], nil
+	default:
+		return nil, fmt.Errorf("%s has multiple EIPs, please specify --%s or --%s.", instanceId, OPT_TO_ENI, OPT_PRIVATE_IP)
+	}
+}
+
+// find the EIP that is replaced by associating to the instance (primary private IP if not specified).
+func findReplacedEIP(eips []*myec2.ChoosableEIP, ins types.Instance, eniId, privateIp string) *myec2.ChoosableEIP {
+	if privateIp == "" {
+		privateIp = convertNilString(ins.PrivateIpAddress)
+	}
+
+	instanceId := convertNilString(ins.InstanceId)
+	for _, e := range eips {
+		if e.InstanceId != instanceId || e.PrivateIP != privateIp {
+			continue
+		}
+		if eniId != "" && e.NetworkInterfaceId != eniId {
+			continue
+		}
+
+		return e
+	}
+
+	return nil
+}
+
+// instance id(Name) private IP, ENI id if not instance, or not associated.
+func eipOwner(e *myec2.ChoosableEIP) string {
+	switch {
+	case e.Idle:
+		return "not associated"
+	case e.InstanceId != "":
+		return fmt.Sprintf("%s(%s) %s", e.InstanceId, e.Name, e.PrivateIP)
+	default:
+		return fmt.Sprintf("%s %s", e.NetworkInterfaceId, e.PrivateIP)
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/urfave/cli/v2"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	EXEC_DESC = `
+	run shell script on the instances with SSM SendCommand (AWS-RunShellScript).
+	the instances are selected by --tag (all conditions are matched), --instance-id or peco.
+	the output is streamed with [Name] prefix while the command is running.
+
+	    rnzoo exec --tag Role=web -- 'systemctl restart app'
+
+	--max-concurrency and --max-errors are number or percentage. (e.g. 10, 10%)
+	SendCommand accepts up to 50 instances, so more instances are sent in batches of 50 one after another.
+	--max-concurrency is applied in each batch, --max-errors is applied to all instances.
+	the remaining batches are not sent after the errors exceeded --max-errors.
+	exit status is non-zero if some instance failed.
+	`
+
+	EXEC_POLL_INTERVAL = 2 * time.Second
+)
+
+var commandExec = cli.Command{
+	Name:        "exec",
+	Category:    CategorySSM,
+	Usage:       "run command on the instances with SSM.",
+	Description: EXEC_DESC,
+	ArgsUsage:   "-- 'command'",
+	Action:      doExec,
+	Flags: append(ssmFlags(),
+		&cli.StringSliceFlag{
+			Name:  OPT_TAG,
+			Usage: "select running instances that have the tag. Key=Value",
+		},
+		&cli.StringFlag{
+			Name:  OPT_MAX_CONCURRENCY,
+			Value: "50",
+			Usage: "max number (or percentage) of the instances that run the command at the same time in each batch of 50 instances.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_MAX_ERRORS,
+			Value: "0",
+			Usage: "max number (or percentage) of errors in all instances before stopping to send the command to other instances.",
+		},
+		&cli.DurationFlag{
+			Name:  OPT_TIMEOUT,
+			Value: 10 * time.Minute,
+			Usage: "execution timeout of the command.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_WITHOUT_CONFIRM,
+			Usage: "without confirm target before action (default action is do confirming)",
+		},
+	),
+}
+
+func parseTagConditions(pairs []string) (map[string]string, error) {
+	conditions := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid tag condition, it must be Key=Value: %s", p)
+		}
+		conditions[kv[0]] = kv[1]
+	}
+
+	return conditions, nil
+}
+
+// select running instances by --tag, --instance-id or peco from the ec2list cache.
+// notes is shown in the picker.
+func selectRunningInstances(c *cli.Context, region string, notes map[string]string) ([]types.Instance, error) {
+	conditions, err := parseTagConditions(c.StringSlice(OPT_TAG))
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := h.LoadInstances(region, c.Bool(OPT_FORCE))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids map[string]bool
+	switch {
+	case len(conditions) > 0:
+	case c.String(OPT_INSTANCE_ID) != "":
+		ids = map[string]bool{c.String(OPT_INSTANCE_ID): true}
+	default:
+		chosen, err := h.ChooseEC2WithNotes(region, myec2.EC2_STATE_RUNNING, false, notes)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = make(map[string]bool, len(chosen))
+		for _, id := range chosen {
+			ids[id] = true
+		}
+	}
+
+	selected := make([]types.Instance, 0)
+	for _, ins := range instances {
+		if ins.State == nil || ins.State.Name != types.InstanceStateNameRunning {
+			continue
+		}
+
+		if ids != nil && !ids[convertNilString(ins.InstanceId)] {
+			continue
+		}
+
+		tags := make(map[string]string, len(ins.Tags))
+		for _, t := range ins.Tags {
+			tags[convertNilString(t.Key)] = convertNilString(t.Value)
+		}
+
+		if matchAllTags(tags, conditions) {
+			selected = append(selected, ins)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("there is no running instance that matches. (please reload instances with -f if the cache is old)")
+	}
+
+	return selected, nil
+}
+
+// prefix each line for distinguishing the instances.
+func printWithPrefix(w io.Writer, prefix, content string) {
+	if content == "" {
+		return
+	}
+
+	s := bufio.NewScanner(strings.NewReader(content))
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		fmt.Fprintf(w, "%s %s\n", prefix, s.Text())
+	}
+}
+
+func doExec(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	if c.Args().Len() == 0 {
+		return ErrExit("required command. e.g. rnzoo exec --tag Role=web -- 'uptime'")
+	}
+	command := strings.Join(c.Args().Slice(), " ")
+
+	ctx := c.Context
+	svc, err := MakeSSMClient(ctx, region)
+	if err != nil {
+		return ErrExit("failed ssm client initialization: %v", err)
+	}
+
+	managed, err := GetSSMManagedInstances(ctx, svc)
+	if err != nil {
+		return ErrExit("failed get SSM managed instances: %v", err)
+	}
+
+	notes := make(map[string]string, len(managed))
+	h, err := NewRnzooCStoreManager()
+	if err == nil {
+		if ec2list, err := h.LoadChoosableEC2List(region, myec2.EC2_STATE_RUNNING, false); err == nil {
+			for _, e := range ec2list {
+				notes[e.InstanceId] = ssmNote(managed, e.InstanceId)
+			}
+		}
+	}
+
+	instances, err := selectRunningInstances(c, region, notes)
+	if err != nil {
+		return ErrExit("error during selecting: %v", err)
+	}
+
+	names := make(map[string]string, len(instances))
+	targetIds := make([]string, 0, len(instances))
+	failed := make([]string, 0)
+	for _, ins := range instances {
+		id := convertNilString(ins.InstanceId)
+		names[id] = getInstanceTag(ins, "Name")
+		if names[id] == "" {
+			names[id] = id
+		}
+
+		if note := ssmNote(managed, id); note != "" {
+			log.Printf("skip %s %s: %s", id, names[id], note)
+			failed = append(failed, id)
+			continue
+		}
+
+		targetIds = append(targetIds, id)
+	}
+
+	if len(targetIds) == 0 {
+		return ErrExit("there is no instance that can run command with SSM.")
+	}
+
+	if !c.Bool(OPT_WITHOUT_CONFIRM) {
+		for _, id := range targetIds {
+			fmt.Printf("%s\t%s\n", id, names[id])
+		}
+		fmt.Printf("command: %s\n", command)
+
+		ans, err := confirm(fmt.Sprintf("run above command on %d instances?", len(targetIds)), false)
+		if err != nil {
+			return ErrExit("failed confirm: %v", err)
+		}
+		if !ans {
+			return ErrExit("canceled exec action.")
+		}
+	}
+
+	maxErrors, err := parseMaxErrors(c.String(OPT_MAX_ERRORS), len(targetIds))
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	timeout := c.Duration(OPT_TIMEOUT)
+	succeeded := 0
+	errCount := 0
+	for i := 0; i < len(targetIds); i += SSM_SEND_COMMAND_MAX_INSTANCES {
+		end := i + SSM_SEND_COMMAND_MAX_INSTANCES
+		if end > len(targetIds) {
+			end = len(targetIds)
+		}
+
+		if errCount > maxErrors {
+			log.Printf("skip %d instances, errors exceeded max-errors %s", len(targetIds)-i, c.String(OPT_MAX_ERRORS))
+			failed = append(failed, targetIds[i:]...)
+			break
+		}
+
+		// the allowance of errors in the remaining instances.
+		batchMaxErrors := strconv.Itoa(maxErrors - errCount)
+		commandId, err := SendShellCommand(ctx, svc, targetIds[i:end], []string{command}, c.String(OPT_MAX_CONCURRENCY), batchMaxErrors, timeout)
+		if err != nil {
+			return ErrExit("failed send command: %v", err)
+		}
+		debug("sent command: " + commandId)
+
+		ok, ng, err := waitShellCommand(ctx, svc, commandId, targetIds[i:end], names)
+		if err != nil {
+			return ErrExit("failed get command status: %v", err)
+		}
+
+		succeeded += ok
+		errCount += len(ng)
+		failed = append(failed, ng...)
+	}
+
+	if len(failed) > 0 {
+		return ErrExit("succeeded %d, failed %d instances: %s", succeeded, len(failed), strings.Join(failed, ","))
+	}
+
+	return OkExit("succeeded %d instances", succeeded)
+}
+
+// max errors count from number or percentage of total.
+func parseMaxErrors(s string, total int) (int, error) {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n > 100 {
+			return 0, fmt.Errorf("invalid max-errors: %s", s)
+		}
+		return total * n / 100, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid max-errors: %s", s)
+	}
+
+	return n, nil
+}
+
+// output of the invocation that is already printed.
+type execOutput struct {
+	Stdout int
+	Stderr int
+}
+
+// print complete lines that are not printed yet. the rest is printed if final.
+func printNewOutput(w io.Writer, prefix, content string, printed *int, final bool) {
+	if len(content) < *printed {
+		// the output was truncated or replaced.
+		*printed = 0
+	}
+
+	out := content[*printed:]
+	if !final {
+		idx := strings.LastIndex(out, "\n")
+		if idx == -1 {
+			return
+		}
+		out = out[:idx+1]
+	}
+
+	printWithPrefix(w, prefix, out)
+	*printed += len(out)
+}
+
+// wait until all invocations of the command finished with streaming output.
+// returns the number of succeeded instances and failed instance ids.
+func waitShellCommand(ctx context.Context, svc *ssm.Client, commandId string, ids []string, names map[string]string) (int, []string, error) {
+	pending := make(map[string]*execOutput, len(ids))
+	for _, id := range ids {
+		pending[id] = &execOutput{}
+	}
+
+	succeeded := 0
+	failed := make([]string, 0)
+	for len(pending) > 0 {
+		time.Sleep(EXEC_POLL_INTERVAL)
+
+		// get the command status before invocations. if it finished, the pending invocations are never run.
+		cmdStatus, err := GetCommandStatus(ctx, svc, commandId)
+		if err != nil {
+			return succeeded, failed, err
+		}
+
+		statuses, err := ListCommandInvocationStatuses(ctx, svc, commandId)
+		if err != nil {
+			return succeeded, failed, err
+		}
+
+		running := make([]string, 0, len(pending))
+		for id := range pending {
+			running = append(running, id)
+		}
+		sort.Strings(running)
+
+		for _, id := range running {
+			status := statuses[id]
+			finished := IsCommandInvocationFinished(status)
+			if status != ssmtypes.CommandInvocationStatusInProgress && !finished {
+				continue
+			}
+
+			prefix := "[" + names[id] + "]"
+			out, err := GetCommandInvocation(ctx, svc, commandId, id)
+			if err != nil {
+				log.Printf("%s failed get output: %v", prefix, err)
+			} else {
+				printNewOutput(os.Stdout, prefix, convertNilString(out.StandardOutputContent), &pending[id].Stdout, finished)
+				printNewOutput(os.Stderr, prefix, convertNilString(out.StandardErrorContent), &pending[id].Stderr, finished)
+			}
+
+			if !finished {
+				continue
+			}
+
+			delete(pending, id)
+			if status == ssmtypes.CommandInvocationStatusSuccess {
+				succeeded++
+			} else {
+				failed = append(failed, id)
+			}
+			log.Printf("%s %s %s", prefix, id, status)
+		}
+
+		if IsCommandFinished(cmdStatus) {
+			for id := range pending {
+				log.Printf("[%s] %s not run (command %s)", names[id], id, cmdStatus)
+				failed = append(failed, id)
+			}
+			break
+		}
+	}
+
+	return succeeded, failed, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+// IAM instance profile is global resource, but use region for loading credentials config.
+func CheckInstanceProfileExists(ctx context.Context, region, profileName string) error {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return err
+	}
+
+	svc := iam.NewFromConfig(cfg)
+
+	params := &iam.GetInstanceProfileInput{
+		InstanceProfileName: aws.String(profileName),
+	}
+
+	// resp is not used. error is returned if the profile does not exist.
+	_, err = svc.GetInstanceProfile(ctx, params)
+	return err
+}
+
+// resolve instance profile name from name: or tag: reference. the name is returned as it is.
+func ResolveInstanceProfileName(ctx context.Context, region, s string) (string, error) {
+	if !myec2.IsResourceRef(s) {
+		return s, nil
+	}
+
+	ref, err := myec2.ParseResourceRef(s)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.Name != "" {
+		return ref.Name, nil
+	}
+
+	if len(ref.Attributes) > 0 {
+		return "", fmt.Errorf("IAM instance profile reference supports only tags: %s", s)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return "", err
+	}
+
+	svc := iam.NewFromConfig(cfg)
+
+	// ListInstanceProfiles does not return tags, so get tags each profile.
+	matched := make([]string, 0, 1)
+	p := iam.NewListInstanceProfilesPaginator(svc, &iam.ListInstanceProfilesInput{})
+	for p.HasMorePages() {
+		resp, err := p.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		for _, profile := range resp.InstanceProfiles {
+			tagResp, err := svc.ListInstanceProfileTags(ctx, &iam.ListInstanceProfileTagsInput{
+				InstanceProfileName: profile.InstanceProfileName,
+			})
+			if err != nil {
+				return "", err
+			}
+
+			tags := make(map[string]string, len(tagResp.Tags))
+			for _, t := range tagResp.Tags {
+				tags[convertNilString(t.Key)] = convertNilString(t.Value)
+			}
+
+			if matchAllTags(tags, ref.Tags) {
+				matched = append(matched, convertNilString(profile.InstanceProfileName))
+			}
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("not found IAM instance profile that matches %s", s)
+	case 1:
+		return matched[0], nil
+	default:
+		return "", fmt.Errorf("%d IAM instance profiles matched %s, please specify more strict reference: %s", len(matched), s, strings.Join(matched, ","))
+	}
+}
+
+func matchAllTags(tags, conditions map[string]string) bool {
+	for k, v := range conditions {
+		if tv, ok := tags[k]; !ok || tv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ARN of the current credentials.
+func GetCallerArn(ctx context.Context, region string) (string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return "", err
+	}
+
+	svc := sts.NewFromConfig(cfg)
+	resp, err := svc.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(resp.Arn), nil
+}
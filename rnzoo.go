@@ -36,6 +36,7 @@ func main() {
 	commands := []*cli.Command{
 		&commandInit,
 		&commandEc2run,
+		&commandLint,
 		&commandEc2list,
 		&commandEc2start,
 		&commandEc2stop,
@@ -45,6 +46,17 @@ func main() {
 		&commandAttachEIP,
 		&commandMoveEIP,
 		&commandDetachEIP,
+		&commandEIP,
+		&commandAMI,
+		&commandVolume,
+		&commandSnapshot,
+		&commandSG,
+		&commandSSH,
+		&commandSSHConfig,
+		&commandCp,
+		&commandSession,
+		&commandForward,
+		&commandExec,
 		&commandGetBilling,
 	}
 	app := &cli.App{
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/urfave/cli/v2"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	CategorySSM = "ssm"
+
+	SESSION_DESC = `
+	select a instance and start SSM session with session-manager-plugin.
+	the instances that are not registered to SSM (or offline) are flagged in the picker.
+	`
+
+	FORWARD_DESC = `
+	select a instance and start port forwarding with SSM session.
+
+	    rnzoo forward --local 5432 --remote 5432
+
+	--remote-host forwards to the host via the instance (e.g. RDS endpoint).
+
+	    rnzoo forward --local 5432 --remote 5432 --remote-host mydb.xxxx.ap-northeast-1.rds.amazonaws.com
+	`
+)
+
+var commandSession = cli.Command{
+	Name:        "session",
+	Category:    CategorySSM,
+	Usage:       "start SSM session to the instance.",
+	Description: SESSION_DESC,
+	Action:      doSession,
+	Flags:       ssmFlags(),
+}
+
+var commandForward = cli.Command{
+	Name:        "forward",
+	Category:    CategorySSM,
+	Usage:       "port forwarding with SSM session.",
+	Description: FORWARD_DESC,
+	Action:      doForward,
+	Flags: append(ssmFlags(),
+		&cli.IntFlag{
+			Name:     OPT_LOCAL,
+			Usage:    "local port number.",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:     OPT_REMOTE,
+			Usage:    "remote port number.",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  OPT_REMOTE_HOST,
+			Usage: "remote host that is forwarded via the instance.",
+		},
+	),
+}
+
+func ssmFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    OPT_REGION,
+			Aliases: []string{"r"},
+			Usage:   EC2LIST_REGION_USAGE,
+		},
+		&cli.BoolFlag{
+			Name:    OPT_FORCE,
+			Aliases: []string{"f"},
+			Usage:   EC2LIST_FORCE_USAGE,
+		},
+		&cli.StringFlag{
+			Name:  OPT_INSTANCE_ID,
+			Usage: "specify instance id.",
+		},
+	}
+}
+
+// select a instance that is online in SSM.
+func chooseSSMInstance(c *cli.Context, svc *ssm.Client, region string) (string, error) {
+	managed, err := GetSSMManagedInstances(c.Context, svc)
+	if err != nil {
+		return "", fmt.Errorf("failed get SSM managed instances: %v", err)
+	}
+
+	instanceId := c.String(OPT_INSTANCE_ID)
+	if instanceId != "" {
+		if err := validateInstanceId(instanceId); err != nil {
+			return "", fmt.Errorf("invalid instance id format: %s", err.Error())
+		}
+	} else {
+		h, err := NewRnzooCStoreManager()
+		if err != nil {
+			return "", err
+		}
+
+		ec2list, err := h.LoadChoosableEC2List(region, myec2.EC2_STATE_RUNNING, c.Bool(OPT_FORCE))
+		if err != nil {
+			return "", err
+		}
+
+		notes := make(map[string]string, len(ec2list))
+		for _, e := range ec2list {
+			notes[e.InstanceId] = ssmNote(managed, e.InstanceId)
+		}
+
+		ids, err := h.ChooseEC2WithNotes(region, myec2.EC2_STATE_RUNNING, false, notes)
+		if err != nil {
+			return "", err
+		}
+
+		if len(ids) != 1 {
+			return "", fmt.Errorf("please select one instance.")
+		}
+		instanceId = ids[0]
+	}
+
+	if note := ssmNote(managed, instanceId); note != "" {
+		return "", fmt.Errorf("%s can not start session: %s", instanceId, note)
+	}
+
+	return instanceId, nil
+}
+
+func ssmNote(managed map[string]string, instanceId string) string {
+	status, ok := managed[instanceId]
+	switch {
+	case !ok:
+		return "[not in SSM]"
+	case status != string(types.PingStatusOnline):
+		return "[SSM " + status + "]"
+	default:
+		return ""
+	}
+}
+
+func doSession(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	svc, err := MakeSSMClient(ctx, region)
+	if err != nil {
+		return ErrExit("failed ssm client initialization: %v", err)
+	}
+
+	instanceId, err := chooseSSMInstance(c, svc, region)
+	if err != nil {
+		return ErrExit("error during selecting: %v", err)
+	}
+
+	if err := StartSSMSession(ctx, svc, region, instanceId, "", nil); err != nil {
+		return ErrExit("failed session: %v", err)
+	}
+
+	return nil
+}
+
+func doForward(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	local, remote := c.Int(OPT_LOCAL), c.Int(OPT_REMOTE)
+	for _, p := range []int{local, remote} {
+		if p <= 0 || p > 65535 {
+			return ErrExit("invalid port: %d", p)
+		}
+	}
+
+	ctx := c.Context
+	svc, err := MakeSSMClient(ctx, region)
+	if err != nil {
+		return ErrExit("failed ssm client initialization: %v", err)
+	}
+
+	instanceId, err := chooseSSMInstance(c, svc, region)
+	if err != nil {
+		return ErrExit("error during selecting: %v", err)
+	}
+
+	document := SSM_DOCUMENT_PORT_FORWARDING
+	params := map[string][]string{
+		"portNumber":      {strconv.Itoa(remote)},
+		"localPortNumber": {strconv.Itoa(local)},
+	}
+
+	if host := c.String(OPT_REMOTE_HOST); host != "" {
+		document = SSM_DOCUMENT_PORT_FORWARDING_REMOTE_HOST
+		params["host"] = []string{host}
+	}
+
+	msg(fmt.Sprintf("forwarding localhost:%d -> %s:%d", local, instanceId, remote))
+	if err := StartSSMSession(ctx, svc, region, instanceId, document, params); err != nil {
+		return ErrExit("failed port forwarding: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/reiki4040/peco"
+	"github.com/urfave/cli/v2"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	CategorySG = "security group"
+
+	SG_DESC = `
+	inspect and edit security groups.
+
+	ls shows inbound/outbound rules of all security groups that attached to the selected instance.
+
+	allow-my-ip adds inbound rule for your global IP (got from checkip.amazonaws.com, /32 or /128 for IPv6) with expiry tag.
+	if the instance has multiple security groups, select the group with peco or --sg-id.
+
+	    rnzoo sg allow-my-ip --port 22 --ttl 2h
+
+	cleanup removes the expired rules that allow-my-ip added. --all removes not expired rules too.
+	`
+
+	CHECK_IP_URL = "https://checkip.amazonaws.com"
+
+	TAG_EXPIRES_AT = "rnzoo:expires-at"
+)
+
+var commandSG = cli.Command{
+	Name:        "sg",
+	Category:    CategorySG,
+	Usage:       "show security group rules of the instance and open port temporarily.",
+	Description: SG_DESC,
+	Subcommands: []*cli.Command{
+		{
+			Name:   "ls",
+			Usage:  "show rules of the security groups that attached to the instance.",
+			Action: doSGList,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:  OPT_INSTANCE_ID,
+					Usage: "specify instance id.",
+				},
+				&cli.BoolFlag{
+					Name:    OPT_TSV,
+					Aliases: []string{"t"},
+					Usage:   EC2LIST_TSV,
+				},
+			},
+		},
+		{
+			Name:   "allow-my-ip",
+			Usage:  "add inbound rule for your IP with expiry.",
+			Action: doSGAllowMyIp,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:  OPT_INSTANCE_ID,
+					Usage: "specify instance id.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_SG_ID,
+					Usage: "specify security group id instead of selecting instance.",
+				},
+				&cli.IntFlag{
+					Name:     OPT_PORT,
+					Usage:    "port number that is allowed.",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  OPT_PROTOCOL,
+					Value: "tcp",
+					Usage: "tcp or udp.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_CIDR,
+					Usage: "allowed CIDR instead of your IP.",
+				},
+				&cli.DurationFlag{
+					Name:  OPT_TTL,
+					Value: time.Hour,
+					Usage: "expiry of the rule. remove the expired rules with sg cleanup.",
+				},
+			},
+		},
+		{
+			Name:   "cleanup",
+			Usage:  "remove the expired rules that allow-my-ip added.",
+			Action: doSGCleanup,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.BoolFlag{
+					Name:  OPT_ALL,
+					Usage: "remove all rules that allow-my-ip added, includes not expired.",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_WITHOUT_CONFIRM,
+					Usage: "without confirm target before action (default action is do confirming)",
+				},
+			},
+		},
+	},
+}
+
+// get global IP address (IPv4 or IPv6) of this machine.
+func getMyIp(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, CHECK_IP_URL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returns status %d", CHECK_IP_URL, resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil {
+		return "", fmt.Errorf("unexpected IP address: %s", string(b))
+	}
+
+	return ip.String(), nil
+}
+
+func chooseInstanceId(c *cli.Context, region string) (string, error) {
+	if instanceId := c.String(OPT_INSTANCE_ID); instanceId != "" {
+		if err := validateInstanceId(instanceId); err != nil {
+			return "", fmt.Errorf("invalid instance id format: %s", err.Error())
+		}
+		return instanceId, nil
+	}
+
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		log.Printf("can not load EC2: %v", err)
+	}
+
+	ids, err := h.ChooseEC2(region, myec2.EC2_STATE_ANY, true)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ids) != 1 {
+		return "", fmt.Errorf("please select one instance.")
+	}
+
+	return ids[0], nil
+}
+
+func doSGList(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	instanceId, err := chooseInstanceId(c, region)
+	if err != nil {
+		return ErrExit("error during selecting: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	groupIds, err := myec2.GetInstanceSecurityGroupIds(ctx, cli, instanceId)
+	if err != nil {
+		return ErrExit("failed get security groups: %v", err)
+	}
+
+	if len(groupIds) == 0 {
+		return ErrExit("%s has no security group.", instanceId)
+	}
+
+	names, err := myec2.GetSecurityGroupNames(ctx, cli, groupIds...)
+	if err != nil {
+		return ErrExit("failed get security groups: %v", err)
+	}
+
+	rules, err := myec2.GetSecurityGroupRulesByGroupIds(ctx, cli, groupIds...)
+	if err != nil {
+		return ErrExit("failed get security group rules: %v", err)
+	}
+
+	w := new(tabwriter.Writer)
+	var b bytes.Buffer
+	w.Init(&b, 10, 0, 2, ' ', 0)
+	for _, r := range rules {
+		r.GroupName = names[r.GroupId]
+		if c.Bool(OPT_TSV) {
+			fmt.Println(r)
+			continue
+		}
+
+		expires := ""
+		if e, ok := r.Tags[TAG_EXPIRES_AT]; ok {
+			expires = "expires:" + e
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s %s\n", r.Direction(), r.GroupId, r.GroupName, r.ProtocolString(), r.PortRange(), r.Peer, r.Description, expires)
+	}
+	w.Flush()
+	fmt.Print(b.String())
+
+	return nil
+}
+
+func chooseSecurityGroupId(ctx context.Context, cli *ec2.Client, instanceId string) (string, error) {
+	groupIds, err := myec2.GetInstanceSecurityGroupIds(ctx, cli, instanceId)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(groupIds) {
+	case 0:
+		return "", fmt.Errorf("%s has no security group.", instanceId)
+	case 1:
+		return groupIds[0], nil
+	}
+
+	names, err := myec2.GetSecurityGroupNames(ctx, cli, groupIds...)
+	if err != nil {
+		return "", err
+	}
+
+	choices := make([]peco.Choosable, 0, len(groupIds))
+	for _, id := range groupIds {
+		choices = append(choices, &peco.Choice{C: id + " " + names[id], V: id})
+	}
+
+	chosens, err := peco.Choose("security group", "select security group that the rule is added", "", choices)
+	if err != nil {
+		return "", err
+	}
+
+	if len(chosens) != 1 {
+		return "", fmt.Errorf("please select one security group.")
+	}
+
+	return chosens[0].Value(), nil
+}
+
+func doSGAllowMyIp(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	port := c.Int(OPT_PORT)
+	if port < 1 || port > 65535 {
+		return ErrExit("invalid port: %d", port)
+	}
+
+	protocol := c.String(OPT_PROTOCOL)
+	if protocol != "tcp" && protocol != "udp" {
+		return ErrExit("protocol must be tcp or udp: %s", protocol)
+	}
+
+	ttl := c.Duration(OPT_TTL)
+	if ttl <= 0 {
+		return ErrExit("ttl must be positive: %s", ttl)
+	}
+
+	ctx := c.Context
+	cidr := c.String(OPT_CIDR)
+	if cidr == "" {
+		ip, err := getMyIp(ctx)
+		if err != nil {
+			return ErrExit("failed get your IP address: %v", err)
+		}
+		// IPv6 if the connection to check IP site is over IPv6.
+		if net.ParseIP(ip).To4() == nil {
+			cidr = ip + "/128"
+		} else {
+			cidr = ip + "/32"
+		}
+	} else if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return ErrExit("invalid CIDR: %v", err)
+	}
+
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	groupId := c.String(OPT_SG_ID)
+	if groupId == "" {
+		instanceId, err := chooseInstanceId(c, region)
+		if err != nil {
+			return ErrExit("error during selecting: %v", err)
+		}
+
+		groupId, err = chooseSecurityGroupId(ctx, cli, instanceId)
+		if err != nil {
+			return ErrExit("error during selecting security group: %v", err)
+		}
+	}
+
+	expiresAt := time.Now().Add(ttl).UTC().Format(time.RFC3339)
+	tags := []types.Tag{
+		{Key: aws.String(TAG_EXPIRES_AT), Value: aws.String(expiresAt)},
+	}
+	description := "rnzoo allow-my-ip until " + expiresAt
+
+	ruleId, err := myec2.AuthorizeIngress(ctx, cli, groupId, protocol, int32(port), cidr, description, tags)
+	if err != nil {
+		return ErrExit("failed add inbound rule: %v", err)
+	}
+
+	return OkExit("allowed rule_id:%s\tgroup_id:%s\t%s/%d from %s\texpires_at:%s", ruleId, groupId, protocol, port, cidr, expiresAt)
+}
+
+func doSGCleanup(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	rules, err := myec2.GetSecurityGroupRules(ctx, cli, types.Filter{
+		Name:   aws.String("tag-key"),
+		Values: []string{TAG_EXPIRES_AT},
+	})
+	if err != nil {
+		return ErrExit("failed get security group rules: %v", err)
+	}
+
+	now := time.Now()
+	targets := make([]*myec2.SecurityGroupRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Egress {
+			continue
+		}
+
+		expiresAt, err := time.Parse(time.RFC3339, r.Tags[TAG_EXPIRES_AT])
+		if err != nil {
+			log.Printf("skip %s that has invalid %s tag: %v", r.RuleId, TAG_EXPIRES_AT, err)
+			continue
+		}
+
+		if c.Bool(OPT_ALL) || expiresAt.Before(now) {
+			targets = append(targets, r)
+		}
+	}
+
+	if len(targets) == 0 {
+		return OkExit("there is no expired rule.")
+	}
+
+	if !c.Bool(OPT_WITHOUT_CONFIRM) {
+		for _, r := range targets {
+			fmt.Printf("%s\t%s\t%s/%s from %s\texpires_at:%s\n", r.RuleId, r.GroupId, r.ProtocolString(), r.PortRange(), r.Peer, r.Tags[TAG_EXPIRES_AT])
+		}
+
+		ans, err := confirm("remove above rules?", false)
+		if err != nil {
+			return ErrExit("failed confirm: %v", err)
+		}
+		if !ans {
+			return ErrExit("canceled cleanup rules action.")
+		}
+	}
+
+	// revoke is per security group.
+	groupRules := make(map[string][]string)
+	groupOrder := make([]string, 0)
+	for _, r := range targets {
+		if _, ok := groupRules[r.GroupId]; !ok {
+			groupOrder = append(groupOrder, r.GroupId)
+		}
+		groupRules[r.GroupId] = append(groupRules[r.GroupId], r.RuleId)
+	}
+
+	for _, groupId := range groupOrder {
+		ruleIds := groupRules[groupId]
+		if err := myec2.RevokeIngressRules(ctx, cli, groupId, ruleIds...); err != nil {
+			return ErrExit("failed remove rules from %s: %v", groupId, err)
+		}
+
+		log.Printf("removed group_id:%s\trule_ids:%s", groupId, strings.Join(ruleIds, ","))
+	}
+
+	return nil
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/urfave/cli/v2"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	CategorySSH = "ssh"
+
+	SSH_DESC = `
+	select a running instance and connect with ssh.
+	the arguments after -- are passed to ssh. leading arguments that start with - are ssh options,
+	and the rest is the remote command.
+
+	    rnzoo ssh -- -L 8080:localhost:80
+	    rnzoo ssh -- -t sudo -i
+
+	address is selected by preference (default: public, private, ipv6).
+	user is decided by --user, the tag rules, the user tag (default: rnzoo:ssh-user), or the AMI platform (ubuntu, admin, ec2-user...).
+	key file is decided by --identity, the tag rules, the instance KeyName mapping in config, or ~/.ssh/<KeyName>.pem if it exists.
+	bastion is used as jump host when the address is private.
+
+	settings in ~/.rnzoo/config
+
+	    [ssh]
+	    address_preference = ["private", "public"]
+	    default_user = "ec2-user"
+	    user_tag = "SSHUser"
+	    bastion = "ec2-user@bastion.example.com"
+	    [ssh.key_files]
+	    my-key = "~/.ssh/my-key.pem"
+	    [[ssh.rules]]
+	    tag = "Role=web"
+	    user = "ubuntu"
+	    identity_file = "~/.ssh/web.pem"
+	`
+
+	ADDRESS_PUBLIC  = "public"
+	ADDRESS_PRIVATE = "private"
+	ADDRESS_IPV6    = "ipv6"
+
+	DEFAULT_SSH_USER     = "ec2-user"
+	DEFAULT_SSH_USER_TAG = "rnzoo:ssh-user"
+)
+
+var (
+	DefaultAddressPreference = []string{ADDRESS_PUBLIC, ADDRESS_PRIVATE, ADDRESS_IPV6}
+
+	// AMI name keyword to login user. the first matched is used.
+	amiNameUsers = []struct {
+		Keyword string
+		User    string
+	}{
+		{"ubuntu", "ubuntu"},
+		{"debian", "admin"},
+		{"centos", "centos"},
+		{"fedora", "fedora"},
+		{"rocky", "rocky"},
+		{"almalinux", "ec2-user"},
+		{"bitnami", "bitnami"},
+		{"amzn", "ec2-user"},
+		{"al2023", "ec2-user"},
+		{"rhel", "ec2-user"},
+		{"suse", "ec2-user"},
+	}
+)
+
+var commandSSH = cli.Command{
+	Name:        "ssh",
+	Category:    CategorySSH,
+	Usage:       "select a running instance and connect with ssh.",
+	Description: SSH_DESC,
+	Action:      doSSH,
+	Flags:       append(sshFlags(), &cli.BoolFlag{Name: OPT_DRYRUN, Usage: "show ssh command without executing."}),
+}
+
+// common flags of the commands that connect with ssh.
+func sshFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    OPT_REGION,
+			Aliases: []string{"r"},
+			Usage:   EC2LIST_REGION_USAGE,
+		},
+		&cli.BoolFlag{
+			Name:    OPT_FORCE,
+			Aliases: []string{"f"},
+			Usage:   EC2LIST_FORCE_USAGE,
+		},
+		&cli.StringFlag{
+			Name:  OPT_INSTANCE_ID,
+			Usage: "specify instance id.",
+		},
+		&cli.StringFlag{
+			Name:    OPT_USER,
+			Aliases: []string{"l"},
+			Usage:   "login user.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_ADDRESS,
+			Usage: "address type: public, private or ipv6. (default: config address_preference)",
+		},
+		&cli.StringFlag{
+			Name:    OPT_JUMP,
+			Aliases: []string{"J"},
+			Usage:   "jump host. it overwrites config bastion.",
+		},
+		&cli.StringFlag{
+			Name:    OPT_IDENTITY,
+			Aliases: []string{"i"},
+			Usage:   "key file. it overwrites config key_files.",
+		},
+	}
+}
+
+// ssh connection for a instance.
+type SSHTarget struct {
+	InstanceId  string
+	Name        string
+	Address     string
+	AddressType string
+	User        string
+	KeyFile     string
+	Jump        string
+}
+
+// ssh options without destination.
+func (t *SSHTarget) Options() []string {
+	opts := make([]string, 0, 4)
+	if t.KeyFile != "" {
+		opts = append(opts, "-i", t.KeyFile)
+	}
+
+	if t.Jump != "" {
+		opts = append(opts, "-J", t.Jump)
+	}
+
+	return opts
+}
+
+func (t *SSHTarget) Destination() string {
+	if t.User == "" {
+		return t.Address
+	}
+
+	return t.User + "@" + t.Address
+}
+
+// connection options from command flags.
+type SSHOptions struct {
+	User    string
+	Address string
+	Jump    string
+	KeyFile string
+}
+
+func NewSSHOptions(c *cli.Context) (*SSHOptions, error) {
+	o := &SSHOptions{
+		User:    c.String(OPT_USER),
+		Address: c.String(OPT_ADDRESS),
+		Jump:    c.String(OPT_JUMP),
+		KeyFile: c.String(OPT_IDENTITY),
+	}
+
+	if o.Address != "" {
+		if err := validateAddressType(o.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+func validateAddressType(t string) error {
+	switch t {
+	case ADDRESS_PUBLIC, ADDRESS_PRIVATE, ADDRESS_IPV6:
+		return nil
+	default:
+		return fmt.Errorf("invalid address type, it must be %s, %s or %s: %s", ADDRESS_PUBLIC, ADDRESS_PRIVATE, ADDRESS_IPV6, t)
+	}
+}
+
+// address preference from option or config.
+func addressPreference(conf *SSHConfig, opts *SSHOptions) ([]string, error) {
+	if opts.Address != "" {
+		return []string{opts.Address}, nil
+	}
+
+	if len(conf.AddressPreference) == 0 {
+		return DefaultAddressPreference, nil
+	}
+
+	for _, p := range conf.AddressPreference {
+		if err := validateAddressType(p); err != nil {
+			return nil, fmt.Errorf("invalid config address_preference: %v", err)
+		}
+	}
+
+	return conf.AddressPreference, nil
+}
+
+// resolve ssh connections of the instances.
+func resolveSSHTargets(ctx context.Context, cli *ec2.Client, conf *SSHConfig, opts *SSHOptions, instances []types.Instance) ([]*SSHTarget, error) {
+	preference, err := addressPreference(conf, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := getInstanceImages(ctx, cli, instances)
+	if err != nil {
+		// user is decided by default if AMI is not found.
+		debug(fmt.Sprintf("failed get AMIs: %v", err))
+	}
+
+	targets := make([]*SSHTarget, 0, len(instances))
+	for _, ins := range instances {
+		t, err := newSSHTarget(conf, opts, preference, ins, images[convertNilString(ins.ImageId)])
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// image is nil if it is unknown.
+func newSSHTarget(conf *SSHConfig, opts *SSHOptions, preference []string, ins types.Instance, image *types.Image) (*SSHTarget, error) {
+	t := &SSHTarget{
+		InstanceId: convertNilString(ins.InstanceId),
+		Name:       getInstanceTag(ins, "Name"),
+	}
+
+	if ins.Platform == types.PlatformValuesWindows {
+		return nil, fmt.Errorf("%s %s is windows instance.", t.InstanceId, t.Name)
+	}
+
+	t.AddressType, t.Address = selectAddress(ins, preference)
+	if t.Address == "" {
+		return nil, fmt.Errorf("%s %s has no %s address.", t.InstanceId, t.Name, strings.Join(preference, "/"))
+	}
+
+	rule := matchSSHRule(conf, ins)
+
+	t.User = decideSSHUser(conf, opts, rule, ins, image)
+
+	t.KeyFile = opts.KeyFile
+	if t.KeyFile == "" && rule != nil && rule.IdentityFile != "" {
+		t.KeyFile = expandHomeDir(rule.IdentityFile)
+	}
+	if t.KeyFile == "" {
+		t.KeyFile = findKeyFile(conf, convertNilString(ins.KeyName))
+	}
+
+	t.Jump = opts.Jump
+	if t.Jump == "" && t.AddressType == ADDRESS_PRIVATE {
+		t.Jump = conf.Bastion
+	}
+
+	return t, nil
+}
+
+// the first rule that the instance matches.
+func matchSSHRule(conf *SSHConfig, ins types.Instance) *SSHRule {
+	for i, r := range conf.Rules {
+		kv := strings.SplitN(r.Tag, "=", 2)
+		for _, t := range ins.Tags {
+			if convertNilString(t.Key) != kv[0] {
+				continue
+			}
+
+			if len(kv) == 1 || convertNilString(t.Value) == kv[1] {
+				return &conf.Rules[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func getInstanceTag(ins types.Instance, key string) string {
+	for _, t := range ins.Tags {
+		if convertNilString(t.Key) == key {
+			return convertNilString(t.Value)
+		}
+	}
+
+	return ""
+}
+
+// image id to AMI map of the instances.
+func getInstanceImages(ctx context.Context, cli *ec2.Client, instances []types.Instance) (map[string]*types.Image, error) {
+	images := make(map[string]*types.Image)
+
+	ids := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		id := convertNilString(ins.ImageId)
+		if _, ok := images[id]; id != "" && !ok {
+			images[id] = nil
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return images, nil
+	}
+
+	resp, err := cli.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: ids})
+	if err != nil {
+		return images, err
+	}
+
+	for i := range resp.Images {
+		images[convertNilString(resp.Images[i].ImageId)] = &resp.Images[i]
+	}
+
+	return images, nil
+}
+
+func selectAddress(ins types.Instance, preference []string) (string, string) {
+	for _, p := range preference {
+		addr := ""
+		switch p {
+		case ADDRESS_PUBLIC:
+			addr = convertNilString(ins.PublicIpAddress)
+		case ADDRESS_PRIVATE:
+			addr = convertNilString(ins.PrivateIpAddress)
+		case ADDRESS_IPV6:
+			addr = convertNilString(ins.Ipv6Address)
+			if addr == "" {
+				for _, ni := range ins.NetworkInterfaces {
+					for _, v6 := range ni.Ipv6Addresses {
+						if a := convertNilString(v6.Ipv6Address); a != "" {
+							addr = a
+							break
+						}
+					}
+					if addr != "" {
+						break
+					}
+				}
+			}
+		}
+
+		if addr != "" {
+			return p, addr
+		}
+	}
+
+	return "", ""
+}
+
+// user priority: option > tag rule > instance user tag > AMI platform > config default_user > ec2-user
+func decideSSHUser(conf *SSHConfig, opts *SSHOptions, rule *SSHRule, ins types.Instance, image *types.Image) string {
+	if opts.User != "" {
+		return opts.User
+	}
+
+	if rule != nil && rule.User != "" {
+		return rule.User
+	}
+
+	userTag := DEFAULT_SSH_USER_TAG
+	if conf.UserTag != "" {
+		userTag = conf.UserTag
+	}
+	if u := getInstanceTag(ins, userTag); u != "" {
+		return u
+	}
+
+	if image != nil {
+		name := strings.ToLower(convertNilString(image.Name) + " " + convertNilString(image.Description))
+		for _, nu := range amiNameUsers {
+			if strings.Contains(name, nu.Keyword) {
+				return nu.User
+			}
+		}
+	}
+
+	if conf.DefaultUser != "" {
+		return conf.DefaultUser
+	}
+
+	return DEFAULT_SSH_USER
+}
+
+// key file from config key_files, or ~/.ssh/<KeyName>.pem, ~/.ssh/<KeyName> if exists.
+// empty means using ssh default (agent, ~/.ssh/config).
+func findKeyFile(conf *SSHConfig, keyName string) string {
+	if keyName == "" {
+		return ""
+	}
+
+	if f, ok := conf.KeyFiles[keyName]; ok {
+		return expandHomeDir(f)
+	}
+
+	for _, f := range []string{"~/.ssh/" + keyName + ".pem", "~/.ssh/" + keyName} {
+		path := expandHomeDir(f)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+func expandHomeDir(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(u.HomeDir, path[2:])
+}
+
+// select running instances with peco or --instance-id, and describe them.
+func chooseRunningInstances(c *cli.Context, cli *ec2.Client, region string) ([]types.Instance, error) {
+	var ids []string
+	if instanceId := c.String(OPT_INSTANCE_ID); instanceId != "" {
+		if err := validateInstanceId(instanceId); err != nil {
+			return nil, fmt.Errorf("invalid instance id format: %s", err.Error())
+		}
+		ids = []string{instanceId}
+	} else {
+		h, err := NewRnzooCStoreManager()
+		if err != nil {
+			return nil, err
+		}
+
+		ids, err = h.ChooseEC2(region, myec2.EC2_STATE_RUNNING, c.Bool(OPT_FORCE))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no instance selected.")
+	}
+
+	// the cache may be old, so check current state.
+	insts, err := myec2.GetInstancesFromId(c.Context, cli, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ins := range insts {
+		if ins.State == nil || ins.State.Name != types.InstanceStateNameRunning {
+			return nil, fmt.Errorf("%s %s is not running, please reload instances with -f.", convertNilString(ins.InstanceId), getInstanceTag(ins, "Name"))
+		}
+	}
+
+	return insts, nil
+}
+
+func doSSH(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	opts, err := NewSSHOptions(c)
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	conf, err := GetSSHConfig()
+	if err != nil {
+		return ErrExit("can not load rnzoo config: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	insts, err := chooseRunningInstances(c, cli, region)
+	if err != nil {
+		return ErrExit("error during selecting: %v", err)
+	}
+
+	if len(insts) != 1 {
+		return ErrExit("please select one instance.")
+	}
+
+	targets, err := resolveSSHTargets(ctx, cli, conf, opts, insts)
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+	t := targets[0]
+
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		return ErrExit("not found ssh command: %v", err)
+	}
+
+	sshOpts, command := splitSSHArgs(c.Args().Slice())
+
+	args := append([]string{"ssh"}, t.Options()...)
+	args = append(args, sshOpts...)
+	args = append(args, t.Destination())
+	args = append(args, command...)
+
+	if c.Bool(OPT_DRYRUN) {
+		fmt.Println(strings.Join(args, " "))
+		return nil
+	}
+
+	msg(fmt.Sprintf("connecting %s %s (%s)", t.InstanceId, t.Name, t.AddressType))
+
+	// replace rnzoo process with ssh for passing the terminal.
+	if err := syscall.Exec(sshPath, args, os.Environ()); err != nil {
+		return ErrExit("failed exec ssh: %v", err)
+	}
+
+	return nil
+}
+
+// ssh options that take an argument.
+const SSH_ARG_OPTIONS = "BbcDEeFIiJLlmOoPpQRSWw"
+
+// split the passthrough arguments into ssh options and remote command.
+func splitSSHArgs(args []string) ([]string, []string) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			return args[:i], args[i+1:]
+		}
+
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			return args[:i], args[i:]
+		}
+
+		// like -L 8080:localhost:80 or -vL 8080:localhost:80. -L8080:localhost:80 has the value in same arg.
+		for j := 1; j < len(a); j++ {
+			if strings.IndexByte(SSH_ARG_OPTIONS, a[j]) >= 0 {
+				if j == len(a)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+
+	return args, nil
+}
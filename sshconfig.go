@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	SSH_CONFIG_DESC = `
+	generate ssh_config Host blocks from the instances (ec2list cache).
+	Host alias is the Name tag (sanitized and de-duplicated with -2, -3...), instance id if no Name tag.
+	HostName, User, IdentityFile and ProxyJump are decided as same as ssh subcommand without AWS access,
+	so the user is not decided by AMI platform. please use the tag rules or the user tag.
+
+	--write writes to ~/.rnzoo/ssh_config, include it from ~/.ssh/config.
+
+	    Include ~/.rnzoo/ssh_config
+	`
+
+	SSH_CONFIG_FILE_NAME = "ssh_config"
+)
+
+var commandSSHConfig = cli.Command{
+	Name:        "ssh-config",
+	Category:    CategorySSH,
+	Usage:       "generate ssh_config from the instances.",
+	Description: SSH_CONFIG_DESC,
+	Action:      doSSHConfig,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    OPT_REGION,
+			Aliases: []string{"r"},
+			Usage:   EC2LIST_REGION_USAGE,
+		},
+		&cli.BoolFlag{
+			Name:    OPT_FORCE,
+			Aliases: []string{"f"},
+			Usage:   EC2LIST_FORCE_USAGE,
+		},
+		&cli.StringFlag{
+			Name:  OPT_ADDRESS,
+			Usage: "address type: public, private or ipv6. (default: config address_preference)",
+		},
+		&cli.StringFlag{
+			Name:    OPT_JUMP,
+			Aliases: []string{"J"},
+			Usage:   "jump host for private hosts. it overwrites config bastion.",
+		},
+		&cli.StringFlag{
+			Name:  OPT_PREFIX,
+			Usage: "prefix of Host alias.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_EC2_ANY_STATE,
+			Usage: "include not running instances.",
+		},
+		&cli.BoolFlag{
+			Name:  OPT_WRITE,
+			Usage: "write to ssh_config file in rnzoo dir instead of stdout.",
+		},
+	},
+}
+
+var hostAliasInvalidChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func sanitizeHostAlias(name string) string {
+	return strings.Trim(hostAliasInvalidChars.ReplaceAllString(name, "-"), "-")
+}
+
+// Host alias to SSHTarget, the aliases are unique.
+type SSHConfigHost struct {
+	Alias  string
+	Target *SSHTarget
+}
+
+func makeSSHConfigHosts(conf *SSHConfig, opts *SSHOptions, instances []types.Instance, prefix string) ([]*SSHConfigHost, error) {
+	preference, err := addressPreference(conf, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]*SSHTarget, 0, len(instances))
+	for _, ins := range instances {
+		// no AWS access, so AMI is unknown.
+		t, err := newSSHTarget(conf, opts, preference, ins, nil)
+		if err != nil {
+			debug(fmt.Sprintf("skip: %v", err))
+			continue
+		}
+		targets = append(targets, t)
+	}
+
+	// stable alias sequence.
+	sort.SliceStable(targets, func(i, j int) bool {
+		if targets[i].Name != targets[j].Name {
+			return targets[i].Name < targets[j].Name
+		}
+		return targets[i].InstanceId < targets[j].InstanceId
+	})
+
+	used := make(map[string]bool, len(targets))
+	hosts := make([]*SSHConfigHost, 0, len(targets))
+	for _, t := range targets {
+		base := sanitizeHostAlias(t.Name)
+		if base == "" {
+			base = t.InstanceId
+		}
+		base = prefix + base
+
+		alias := base
+		for i := 2; used[alias]; i++ {
+			alias = base + "-" + strconv.Itoa(i)
+		}
+		used[alias] = true
+
+		hosts = append(hosts, &SSHConfigHost{Alias: alias, Target: t})
+	}
+
+	return hosts, nil
+}
+
+func formatSSHConfig(region string, hosts []*SSHConfigHost) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# generated by rnzoo ssh-config (region: %s). do not edit, it is overwritten.\n", region)
+	for _, h := range hosts {
+		t := h.Target
+		fmt.Fprintf(&b, "\nHost %s\n", h.Alias)
+		fmt.Fprintf(&b, "    # %s %s\n", t.InstanceId, t.Name)
+		fmt.Fprintf(&b, "    HostName %s\n", t.Address)
+		if t.User != "" {
+			fmt.Fprintf(&b, "    User %s\n", t.User)
+		}
+		if t.KeyFile != "" {
+			fmt.Fprintf(&b, "    IdentityFile %s\n", t.KeyFile)
+			fmt.Fprintf(&b, "    IdentitiesOnly yes\n")
+		}
+		if t.Jump != "" {
+			fmt.Fprintf(&b, "    ProxyJump %s\n", t.Jump)
+		}
+	}
+
+	return b.String()
+}
+
+func doSSHConfig(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	opts, err := NewSSHOptions(c)
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	conf, err := GetSSHConfig()
+	if err != nil {
+		return ErrExit("can not load rnzoo config: %v", err)
+	}
+
+	err = CreateRnzooDir()
+	if err != nil {
+		return ErrExit("can not create rnzoo dir: %s", err.Error())
+	}
+
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		return ErrExit("can not load EC2: %v", err)
+	}
+
+	instances, err := h.LoadInstances(region, c.Bool(OPT_FORCE))
+	if err != nil {
+		return ErrExit("can not load EC2: %v", err)
+	}
+
+	targets := make([]types.Instance, 0, len(instances))
+	for _, ins := range instances {
+		if ins.State == nil || ins.State.Name == types.InstanceStateNameTerminated {
+			continue
+		}
+
+		if !c.Bool(OPT_EC2_ANY_STATE) && ins.State.Name != types.InstanceStateNameRunning {
+			continue
+		}
+
+		targets = append(targets, ins)
+	}
+
+	hosts, err := makeSSHConfigHosts(conf, opts, targets, c.String(OPT_PREFIX))
+	if err != nil {
+		return ErrExit("%v", err)
+	}
+
+	out := formatSSHConfig(region, hosts)
+	if !c.Bool(OPT_WRITE) {
+		fmt.Print(out)
+		return nil
+	}
+
+	dir, err := GetRnzooDir()
+	if err != nil {
+		return ErrExit("can not get rnzoo dir: %v", err)
+	}
+
+	path := filepath.Join(dir, SSH_CONFIG_FILE_NAME)
+	if err := os.WriteFile(path, []byte(out), 0600); err != nil {
+		return ErrExit("failed write ssh_config: %v", err)
+	}
+
+	log.Printf("wrote %d hosts to %s", len(hosts), path)
+	msg(fmt.Sprintf("please add 'Include %s' to ~/.ssh/config if not yet.", path))
+
+	return nil
+}
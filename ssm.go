@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	SESSION_MANAGER_PLUGIN = "session-manager-plugin"
+
+	SSM_DOCUMENT_PORT_FORWARDING             = "AWS-StartPortForwardingSession"
+	SSM_DOCUMENT_PORT_FORWARDING_REMOTE_HOST = "AWS-StartPortForwardingSessionToRemoteHost"
+	SSM_DOCUMENT_RUN_SHELL_SCRIPT            = "AWS-RunShellScript"
+
+	// SendCommand accepts up to 50 instance ids.
+	SSM_SEND_COMMAND_MAX_INSTANCES = 50
+)
+
+func MakeSSMClient(ctx context.Context, region string) (*ssm.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	return ssm.NewFromConfig(cfg), nil
+}
+
+// get the SSM parameter value. e.g. /aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64
+func GetSSMParameter(ctx context.Context, region, name string) (string, error) {
+	svc, err := MakeSSMClient(ctx, region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := svc.GetParameter(ctx, &ssm.GetParameterInput{
+		Name: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return convertNilString(resp.Parameter.Value), nil
+}
+
+// instance id to ping status of the instances that registered to SSM.
+func GetSSMManagedInstances(ctx context.Context, svc *ssm.Client) (map[string]string, error) {
+	managed := make(map[string]string)
+	p := ssm.NewDescribeInstanceInformationPaginator(svc, &ssm.DescribeInstanceInformationInput{})
+	for p.HasMorePages() {
+		resp, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, info := range resp.InstanceInformationList {
+			managed[convertNilString(info.InstanceId)] = string(info.PingStatus)
+		}
+	}
+
+	return managed, nil
+}
+
+// start session and pass it to session-manager-plugin.
+// the plugin handles the terminal or the port forwarding until the session ends.
+func StartSSMSession(ctx context.Context, svc *ssm.Client, region, target, document string, params map[string][]string) error {
+	pluginPath, err := exec.LookPath(SESSION_MANAGER_PLUGIN)
+	if err != nil {
+		return fmt.Errorf("not found %s, please install it: %v", SESSION_MANAGER_PLUGIN, err)
+	}
+
+	in := &ssm.StartSessionInput{
+		Target: aws.String(target),
+	}
+	if document != "" {
+		in.DocumentName = aws.String(document)
+		in.Parameters = params
+	}
+
+	resp, err := svc.StartSession(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	session, err := json.Marshal(map[string]string{
+		"SessionId":  convertNilString(resp.SessionId),
+		"StreamUrl":  convertNilString(resp.StreamUrl),
+		"TokenValue": convertNilString(resp.TokenValue),
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("https://ssm.%s.amazonaws.com", region)
+	cmd := exec.Command(pluginPath, string(session), region, "StartSession", os.Getenv("AWS_PROFILE"), string(request), endpoint)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Ctrl-C is handled by the plugin (it is sent to the remote).
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+
+	return cmd.Run()
+}
+
+// send shell script to the instances with AWS-RunShellScript.
+// maxConcurrency and maxErrors are number or percentage. (e.g. "10", "10%")
+func SendShellCommand(ctx context.Context, svc *ssm.Client, instanceIds []string, commands []string, maxConcurrency, maxErrors string, timeout time.Duration) (string, error) {
+	params := &ssm.SendCommandInput{
+		DocumentName: aws.String(SSM_DOCUMENT_RUN_SHELL_SCRIPT),
+		InstanceIds:  instanceIds,
+		Parameters: map[string][]string{
+			"commands":         commands,
+			"executionTimeout": {strconv.Itoa(int(timeout.Seconds()))},
+		},
+		Comment: aws.String("rnzoo exec"),
+	}
+
+	if maxConcurrency != "" {
+		params.MaxConcurrency = aws.String(maxConcurrency)
+	}
+
+	if maxErrors != "" {
+		params.MaxErrors = aws.String(maxErrors)
+	}
+
+	resp, err := svc.SendCommand(ctx, params)
+	if err != nil {
+		return "", err
+	}
+
+	return convertNilString(resp.Command.CommandId), nil
+}
+
+// instance id to invocation status of the command.
+func ListCommandInvocationStatuses(ctx context.Context, svc *ssm.Client, commandId string) (map[string]ssmtypes.CommandInvocationStatus, error) {
+	statuses := make(map[string]ssmtypes.CommandInvocationStatus)
+	p := ssm.NewListCommandInvocationsPaginator(svc, &ssm.ListCommandInvocationsInput{
+		CommandId: aws.String(commandId),
+	})
+	for p.HasMorePages() {
+		resp, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, i := range resp.CommandInvocations {
+			statuses[convertNilString(i.InstanceId)] = i.Status
+		}
+	}
+
+	return statuses, nil
+}
+
+func IsCommandInvocationFinished(status ssmtypes.CommandInvocationStatus) bool {
+	switch status {
+	case ssmtypes.CommandInvocationStatusSuccess,
+		ssmtypes.CommandInvocationStatusFailed,
+		ssmtypes.CommandInvocationStatusTimedOut,
+		ssmtypes.CommandInvocationStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// status of the whole command.
+func GetCommandStatus(ctx context.Context, svc *ssm.Client, commandId string) (ssmtypes.CommandStatus, error) {
+	resp, err := svc.ListCommands(ctx, &ssm.ListCommandsInput{
+		CommandId: aws.String(commandId),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp.Commands) != 1 {
+		return "", fmt.Errorf("not found command: %s", commandId)
+	}
+
+	return resp.Commands[0].Status, nil
+}
+
+func IsCommandFinished(status ssmtypes.CommandStatus) bool {
+	switch status {
+	case ssmtypes.CommandStatusSuccess,
+		ssmtypes.CommandStatusFailed,
+		ssmtypes.CommandStatusTimedOut,
+		ssmtypes.CommandStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+func GetCommandInvocation(ctx context.Context, svc *ssm.Client, commandId, instanceId string) (*ssm.GetCommandInvocationOutput, error) {
+	return svc.GetCommandInvocation(ctx, &ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandId),
+		InstanceId: aws.String(instanceId),
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+	"text/template"
+)
+
+const (
+	// EC2 user data limit is 16KB before base64 encoding.
+	USER_DATA_MAX_BYTES = 16 * 1024
+)
+
+// content type prefixes of cloud-init user data formats.
+var userDataContentTypes = []struct {
+	Prefix      string
+	ContentType string
+}{
+	{"#!", "text/x-shellscript"},
+	{"#cloud-config", "text/cloud-config"},
+	{"#include", "text/x-include-url"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#part-handler", "text/part-handler"},
+	{"#upstart-job", "text/upstart-job"},
+}
+
+type EC2RunUserDataPart struct {
+	File        string `yaml:"file,omitempty"`
+	Content     string `yaml:"content,omitempty"`
+	ContentType string `yaml:"content_type,omitempty"`
+	// not render the part as template.
+	Raw bool `yaml:"raw,omitempty"`
+}
+
+// values for user data template.
+type UserDataReplacement struct {
+	Name     string
+	Symbol   string
+	Sequence string
+	Vars     map[string]string
+}
+
+func (r *UserDataReplacement) StringWithTemplate(templateString string) (string, error) {
+	t := template.New("user data template").Option("missingkey=error")
+	t, err := t.Parse(templateString)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	err = t.Execute(buf, r)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// EC2 user data that rendered.
+type UserData struct {
+	// rendered plain text. (multipart MIME if there are multiple parts)
+	Text string
+	// the data for EC2. it is gzip compressed if text is over the limit.
+	Data       string
+	Compressed bool
+}
+
+// render user data parts with template (unless disabled) and assemble them.
+// user_data, user_data_file and user_data_parts are assembled as multipart MIME if there are multiple parts.
+func (c *EC2RunConfig) renderUserData(r *UserDataReplacement) (*UserData, error) {
+	parts := make([]EC2RunUserDataPart, 0, len(c.UserDataParts)+2)
+	if c.UserData != "" {
+		parts = append(parts, EC2RunUserDataPart{Content: c.UserData})
+	}
+	if c.UserDataFile != "" {
+		parts = append(parts, EC2RunUserDataPart{File: c.UserDataFile})
+	}
+	parts = append(parts, c.UserDataParts...)
+
+	if len(parts) == 0 {
+		return &UserData{}, nil
+	}
+
+	contents := make([]string, 0, len(parts))
+	for i, p := range parts {
+		content := p.Content
+		if p.File != "" {
+			b, err := os.ReadFile(p.File)
+			if err != nil {
+				return nil, fmt.Errorf("failed read user data file: %v", err)
+			}
+			content = string(b)
+		}
+
+		// scripts may contain {{ }} for other tools, so template can be disabled.
+		if c.useUserDataTemplate() && !p.Raw {
+			rendered, err := r.StringWithTemplate(content)
+			if err != nil {
+				return nil, fmt.Errorf("user data part %d: %v", i+1, err)
+			}
+			content = rendered
+		}
+		contents = append(contents, content)
+	}
+
+	text := contents[0]
+	if len(parts) > 1 || parts[0].ContentType != "" {
+		var err error
+		text, err = assembleMultipartUserData(parts, contents)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	u := &UserData{
+		Text: text,
+		Data: text,
+	}
+
+	if len(text) > USER_DATA_MAX_BYTES {
+		compressed, err := gzipString(text)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(compressed) > USER_DATA_MAX_BYTES {
+			return nil, fmt.Errorf("user data is too large: %d bytes (gzip %d bytes), limit is %d bytes", len(text), len(compressed), USER_DATA_MAX_BYTES)
+		}
+
+		u.Data = compressed
+		u.Compressed = true
+	}
+
+	return u, nil
+}
+
+func (c *EC2RunConfig) useUserDataTemplate() bool {
+	return c.UserDataTemplate == nil || *c.UserDataTemplate
+}
+
+func detectUserDataContentType(content string) string {
+	for _, t := range userDataContentTypes {
+		if strings.HasPrefix(content, t.Prefix) {
+			return t.ContentType
+		}
+	}
+
+	return "text/plain"
+}
+
+// assemble cloud-init multipart MIME user data.
+func assembleMultipartUserData(parts []EC2RunUserDataPart, contents []string) (string, error) {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+
+	for i, p := range parts {
+		contentType := p.ContentType
+		if contentType == "" {
+			contentType = detectUserDataContentType(contents[i])
+		}
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", contentType+"; charset=\"utf-8\"")
+		h.Set("MIME-Version", "1.0")
+		h.Set("Content-Transfer-Encoding", "7bit")
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"part-%03d\"", i+1))
+
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := pw.Write([]byte(contents[i])); err != nil {
+			return "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	header := fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n", w.Boundary())
+	return header + body.String(), nil
+}
+
+func gzipString(s string) (string, error) {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	if _, err := gw.Write([]byte(s)); err != nil {
+		return "", err
+	}
+
+	if err := gw.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (p *EC2RunUserDataPart) validate() error {
+	if p.File == "" && p.Content == "" {
+		return errors.New("file or content is required")
+	}
+
+	if p.File != "" && p.Content != "" {
+		return errors.New("can not set both file and content")
+	}
+
+	return nil
+}
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/urfave/cli/v2"
+
+	myec2 "github.com/reiki4040/rnzoo/ec2"
+)
+
+const (
+	CategoryEBS = "ebs"
+
+	VOL_DESC = `
+	manage EBS volumes.
+
+	ls shows volumes with the attached instance Name (from ec2list cache).
+	attach/detach/resize select volumes with peco if --volume-id is not specified.
+	resize does not extend the file system, please extend it in the instance after resizing.
+
+	report shows unattached volumes and orphaned snapshots with estimated monthly cost.
+	orphaned snapshots are the snapshots that the source volume was deleted and no own AMI uses.
+	the cost is estimated with us-east-1 prices, snapshot cost is upper bound (snapshot is incremental).
+	`
+
+	SNAPSHOT_DESC = `
+	manage EBS snapshots.
+
+	create snapshots of the selected volumes, and copy the selected snapshots to other region.
+
+	    rnzoo snapshot copy-region --to-region us-west-2
+	`
+
+	DEFAULT_ATTACH_DEVICE = "/dev/sdf"
+)
+
+var commandVolume = cli.Command{
+	Name:        "vol",
+	Aliases:     []string{"volume"},
+	Category:    CategoryEBS,
+	Usage:       "list, attach, detach and resize EBS volumes.",
+	Description: VOL_DESC,
+	Subcommands: []*cli.Command{
+		{
+			Name:   "ls",
+			Usage:  "list EBS volumes.",
+			Action: doVolumeList,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.BoolFlag{
+					Name:  OPT_AVAILABLE,
+					Usage: "list only unattached (available) volumes.",
+				},
+				&cli.BoolFlag{
+					Name:    OPT_TSV,
+					Aliases: []string{"t"},
+					Usage:   EC2LIST_TSV,
+				},
+			},
+		},
+		{
+			Name:   "attach",
+			Usage:  "attach the volume to the instance.",
+			Action: doVolumeAttach,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:  OPT_VOLUME_ID,
+					Usage: "specify volume id.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_INSTANCE_ID,
+					Usage: "specify instance id.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_DEVICE,
+					Value: DEFAULT_ATTACH_DEVICE,
+					Usage: "device name.",
+				},
+			},
+		},
+		{
+			Name:   "detach",
+			Usage:  "detach the volumes from the instances.",
+			Action: doVolumeDetach,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:  OPT_VOLUME_ID,
+					Usage: "specify volume id.",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_FORCE_DETACH,
+					Usage: "force detachment. it may lose data, use it as last resort.",
+				},
+				&cli.BoolFlag{
+					Name:  OPT_WITHOUT_CONFIRM,
+					Usage: "without confirm target before action (default action is do confirming)",
+				},
+			},
+		},
+		{
+			Name:   "resize",
+			Usage:  "resize the volume.",
+			Action: doVolumeResize,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:  OPT_VOLUME_ID,
+					Usage: "specify volume id.",
+				},
+				&cli.IntFlag{
+					Name:     OPT_SIZE,
+					Usage:    "new size (GB). it must be larger than current size.",
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:   "report",
+			Usage:  "report unattached volumes and orphaned snapshots with estimated monthly cost.",
+			Action: doVolumeReport,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+			},
+		},
+	},
+}
+
+var commandSnapshot = cli.Command{
+	Name:        "snapshot",
+	Aliases:     []string{"snap"},
+	Category:    CategoryEBS,
+	Usage:       "create, list, remove and copy EBS snapshots.",
+	Description: SNAPSHOT_DESC,
+	Subcommands: []*cli.Command{
+		{
+			Name:   "create",
+			Usage:  "create snapshots of the volumes.",
+			Action: doSnapshotCreate,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:  OPT_VOLUME_ID,
+					Usage: "specify volume id.",
+				},
+				&cli.StringFlag{
+					Name:  OPT_DESCRIPTION,
+					Usage: "snapshot description.",
+				},
+			},
+		},
+		{
+			Name:   "ls",
+			Usage:  "list own snapshots.",
+			Action: doSnapshotList,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.BoolFlag{
+					Name:    OPT_TSV,
+					Aliases: []string{"t"},
+					Usage:   EC2LIST_TSV,
+				},
+			},
+		},
+		{
+			Name:   "rm",
+			Usage:  "delete the selected snapshots.",
+			Action: doSnapshotRemove,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.BoolFlag{
+					Name:  OPT_WITHOUT_CONFIRM,
+					Usage: "without confirm target before action (default action is do confirming)",
+				},
+			},
+		},
+		{
+			Name:   "copy-region",
+			Usage:  "copy the selected snapshots to other region.",
+			Action: doSnapshotCopyRegion,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    OPT_REGION,
+					Aliases: []string{"r"},
+					Usage:   EC2LIST_REGION_USAGE,
+				},
+				&cli.StringFlag{
+					Name:     OPT_TO_REGION,
+					Usage:    "destination region.",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  OPT_DESCRIPTION,
+					Usage: "snapshot description in destination region.",
+				},
+			},
+		},
+	},
+}
+
+// instance id to Name tag map from ec2list cache.
+// it loads from AWS if there is no cache.
+func loadInstanceNames(region string) map[string]string {
+	names := make(map[string]string)
+
+	h, err := NewRnzooCStoreManager()
+	if err != nil {
+		debug(fmt.Sprintf("can not load EC2: %v", err))
+		return names
+	}
+
+	ec2list, err := h.LoadChoosableEC2List(region, myec2.EC2_STATE_ANY, false)
+	if err != nil {
+		debug(fmt.Sprintf("can not load EC2: %v", err))
+		return names
+	}
+
+	for _, e := range ec2list {
+		names[e.InstanceId] = e.Name
+	}
+
+	return names
+}
+
+func loadVolumes(ctx context.Context, cli *ec2.Client, region string, state types.VolumeState) ([]*myec2.ChoosableVolume, error) {
+	volumes, err := myec2.GetVolumes(ctx, cli, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(volumes) == 0 {
+		return nil, fmt.Errorf("there is no volume.")
+	}
+
+	return myec2.ConvertChoosableVolumeList(volumes, loadInstanceNames(region)), nil
+}
+
+// select volumes with peco, or the specified volume.
+func chooseVolumes(c *cli.Context, cli *ec2.Client, region string, state types.VolumeState) ([]*myec2.ChoosableVolume, error) {
+	volumes, err := loadVolumes(c.Context, cli, region, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if volumeId := c.String(OPT_VOLUME_ID); volumeId != "" {
+		for _, v := range volumes {
+			if v.VolumeId == volumeId {
+				return []*myec2.ChoosableVolume{v}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("not found volume %s (state: %s)", volumeId, state)
+	}
+
+	return myec2.ChooseVolume(volumes)
+}
+
+func doVolumeList(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	var state types.VolumeState
+	if c.Bool(OPT_AVAILABLE) {
+		state = types.VolumeStateAvailable
+	}
+
+	volumes, err := loadVolumes(ctx, cli, region, state)
+	if err != nil {
+		return ErrExit("can not load volumes: %v", err)
+	}
+
+	for _, v := range volumes {
+		if c.Bool(OPT_TSV) {
+			fmt.Println(v)
+		} else {
+			fmt.Println(v.Choice())
+		}
+	}
+
+	return nil
+}
+
+func doVolumeAttach(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	volumes, err := chooseVolumes(c, cli, region, types.VolumeStateAvailable)
+	if err != nil {
+		return ErrExit("error during selecting volume: %v", err)
+	}
+
+	if len(volumes) != 1 {
+		return ErrExit("please select one volume.")
+	}
+
+	instanceId := c.String(OPT_INSTANCE_ID)
+	if instanceId == "" {
+		h, err := NewRnzooCStoreManager()
+		if err != nil {
+			log.Printf("can not load EC2: %v", err)
+		}
+
+		ids, err := h.ChooseEC2(region, myec2.EC2_STATE_ANY, true)
+		if err != nil {
+			return ErrExit("error during selecting: %s", err.Error())
+		}
+
+		if len(ids) != 1 {
+			return ErrExit("please select one instance.")
+		}
+		instanceId = ids[0]
+	} else if err := validateInstanceId(instanceId); err != nil {
+		return ErrExit("invalid instance id format: %s", err.Error())
+	}
+
+	v := volumes[0]
+	if err := myec2.AttachVolume(ctx, cli, v.VolumeId, instanceId, c.String(OPT_DEVICE)); err != nil {
+		return ErrExit("failed attach volume: %v", err)
+	}
+
+	return OkExit("attaching volume_id:%s\tinstance_id:%s\tdevice:%s", v.VolumeId, instanceId, c.String(OPT_DEVICE))
+}
+
+func doVolumeDetach(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	volumes, err := chooseVolumes(c, cli, region, types.VolumeStateInUse)
+	if err != nil {
+		return ErrExit("error during selecting volume: %v", err)
+	}
+
+	if !c.Bool(OPT_WITHOUT_CONFIRM) {
+		for _, v := range volumes {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", v.VolumeId, v.Name, v.InstanceId, v.InstanceName, v.Device)
+		}
+
+		ans, err := confirm("detach above volumes?", false)
+		if err != nil {
+			return ErrExit("failed confirm: %v", err)
+		}
+		if !ans {
+			return ErrExit("canceled detach volume action.")
+		}
+	}
+
+	for _, v := range volumes {
+		if err := myec2.DetachVolume(ctx, cli, v.VolumeId, c.Bool(OPT_FORCE_DETACH)); err != nil {
+			return ErrExit("failed detach volume %s: %v", v.VolumeId, err)
+		}
+
+		log.Printf("detaching volume_id:%s\tinstance_id:%s\tdevice:%s", v.VolumeId, v.InstanceId, v.Device)
+	}
+
+	return nil
+}
+
+func doVolumeResize(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	volumes, err := chooseVolumes(c, cli, region, "")
+	if err != nil {
+		return ErrExit("error during selecting volume: %v", err)
+	}
+
+	if len(volumes) != 1 {
+		return ErrExit("please select one volume.")
+	}
+
+	v := volumes[0]
+	size := int32(c.Int(OPT_SIZE))
+	if size <= v.SizeGB {
+		return ErrExit("new size must be larger than current size %dGB: %dGB", v.SizeGB, size)
+	}
+
+	if err := myec2.ResizeVolume(ctx, cli, v.VolumeId, size); err != nil {
+		return ErrExit("failed resize volume: %v", err)
+	}
+
+	log.Printf("resizing volume_id:%s\t%dGB -> %dGB", v.VolumeId, v.SizeGB, size)
+	if v.InstanceId != "" {
+		log.Printf("please extend the file system in %s %s.", v.InstanceId, v.InstanceName)
+	}
+
+	return nil
+}
+
+func doVolumeReport(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	volumes, err := myec2.GetVolumes(ctx, cli, types.VolumeStateAvailable)
+	if err != nil {
+		return ErrExit("failed get volumes: %v", err)
+	}
+
+	volumeTotal := 0.0
+	fmt.Println("# unattached volumes")
+	for _, v := range myec2.ConvertChoosableVolumeList(volumes, nil) {
+		cost := v.MonthlyCost()
+		volumeTotal += cost
+		fmt.Printf("%s\t%s\t%dGB\t%s\t%s\t%.2f USD/month\n", v.VolumeId, v.Name, v.SizeGB, v.VolumeType, v.CreateTime.Format("2006-01-02"), cost)
+	}
+	fmt.Printf("%d volumes, estimated %.2f USD/month\n\n", len(volumes), volumeTotal)
+
+	snapshots, err := myec2.GetSnapshots(ctx, cli)
+	if err != nil {
+		return ErrExit("failed get snapshots: %v", err)
+	}
+
+	orphaned, err := myec2.FindOrphanedSnapshots(ctx, cli, snapshots)
+	if err != nil {
+		return ErrExit("failed find orphaned snapshots: %v", err)
+	}
+
+	snapshotTotal := 0.0
+	fmt.Println("# orphaned snapshots")
+	for _, s := range myec2.ConvertChoosableSnapshotList(orphaned) {
+		cost := s.MonthlyCost()
+		snapshotTotal += cost
+		fmt.Printf("%s\t%s\t%s\t%dGB\t%s\t%.2f USD/month\n", s.SnapshotId, s.Name, s.VolumeId, s.SizeGB, s.StartTime.Format("2006-01-02"), cost)
+	}
+	fmt.Printf("%d snapshots, estimated up to %.2f USD/month\n", len(orphaned), snapshotTotal)
+
+	return nil
+}
+
+func doSnapshotCreate(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	volumes, err := chooseVolumes(c, cli, region, "")
+	if err != nil {
+		return ErrExit("error during selecting volume: %v", err)
+	}
+
+	for _, v := range volumes {
+		var tags []types.Tag
+		if v.Name != "" {
+			tags = []types.Tag{{Key: aws.String("Name"), Value: aws.String(v.Name)}}
+		}
+
+		snapshotId, err := myec2.CreateSnapshot(ctx, cli, v.VolumeId, c.String(OPT_DESCRIPTION), tags)
+		if err != nil {
+			return ErrExit("failed create snapshot of %s: %v", v.VolumeId, err)
+		}
+
+		log.Printf("creating snapshot_id:%s\tvolume_id:%s\tname:%s", snapshotId, v.VolumeId, v.Name)
+	}
+
+	return nil
+}
+
+func loadSnapshots(ctx context.Context, cli *ec2.Client) ([]*myec2.ChoosableSnapshot, error) {
+	snapshots, err := myec2.GetSnapshots(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("there is no snapshot.")
+	}
+
+	return myec2.ConvertChoosableSnapshotList(snapshots), nil
+}
+
+func doSnapshotList(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	snapshots, err := loadSnapshots(ctx, cli)
+	if err != nil {
+		return ErrExit("can not load snapshots: %v", err)
+	}
+
+	for _, s := range snapshots {
+		if c.Bool(OPT_TSV) {
+			fmt.Println(s)
+		} else {
+			fmt.Println(s.Choice())
+		}
+	}
+
+	return nil
+}
+
+func doSnapshotRemove(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	snapshots, err := loadSnapshots(ctx, cli)
+	if err != nil {
+		return ErrExit("can not load snapshots: %v", err)
+	}
+
+	selected, err := myec2.ChooseSnapshot(snapshots)
+	if err != nil {
+		return ErrExit("error during selecting: %v", err)
+	}
+
+	if !c.Bool(OPT_WITHOUT_CONFIRM) {
+		for _, s := range selected {
+			fmt.Printf("%s\t%s\t%s\t%dGB\n", s.SnapshotId, s.Name, s.VolumeId, s.SizeGB)
+		}
+
+		ans, err := confirm("delete above snapshots?", false)
+		if err != nil {
+			return ErrExit("failed confirm: %v", err)
+		}
+		if !ans {
+			return ErrExit("canceled delete snapshot action.")
+		}
+	}
+
+	failed := make([]string, 0)
+	for _, s := range selected {
+		// the snapshot that AMI uses can not be deleted.
+		if err := myec2.DeleteSnapshot(ctx, cli, s.SnapshotId); err != nil {
+			log.Printf("failed delete snapshot %s: %v", s.SnapshotId, err)
+			failed = append(failed, s.SnapshotId)
+			continue
+		}
+
+		log.Printf("deleted snapshot_id:%s\tname:%s", s.SnapshotId, s.Name)
+	}
+
+	if len(failed) > 0 {
+		return ErrExit("failed delete snapshots: %s", strings.Join(failed, ","))
+	}
+
+	return nil
+}
+
+func doSnapshotCopyRegion(c *cli.Context) error {
+	prepare(c)
+
+	region, err := getRegion(c)
+	if err != nil {
+		return ErrExit("failed get region: %v", err)
+	}
+
+	toRegion := c.String(OPT_TO_REGION)
+	if toRegion == region {
+		return ErrExit("destination region is same as source region: %s", region)
+	}
+
+	ctx := c.Context
+	cli, err := myec2.MakeEC2Client(ctx, region)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	destCli, err := myec2.MakeEC2Client(ctx, toRegion)
+	if err != nil {
+		return ErrExit("failed ec2 client initialization: %v", err)
+	}
+
+	snapshots, err := loadSnapshots(ctx, cli)
+	if err != nil {
+		return ErrExit("can not load snapshots: %v", err)
+	}
+
+	selected, err := myec2.ChooseSnapshot(snapshots)
+	if err != nil {
+		return ErrExit("error during selecting: %v", err)
+	}
+
+	for _, s := range selected {
+		description := c.String(OPT_DESCRIPTION)
+		if description == "" {
+			description = fmt.Sprintf("copied from %s %s", region, s.SnapshotId)
+		}
+
+		var tags []types.Tag
+		if s.Name != "" {
+			tags = []types.Tag{{Key: aws.String("Name"), Value: aws.String(s.Name)}}
+		}
+
+		copiedId, err := myec2.CopySnapshot(ctx, destCli, region, s.SnapshotId, description, tags)
+		if err != nil {
+			return ErrExit("failed copy snapshot %s: %v", s.SnapshotId, err)
+		}
+
+		log.Printf("copying %s:%s -> %s:%s", region, s.SnapshotId, toRegion, copiedId)
+	}
+
+	return nil
+}